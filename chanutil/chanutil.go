@@ -8,7 +8,10 @@ package chanutil
 
 import (
 	"errors"
+	"iter"
+	"slices"
 	"sync"
+	"time"
 )
 
 var (
@@ -18,49 +21,233 @@ var (
 // Chan id.
 type ChanID int64
 
-// Chanmap can create and index chans. Every chan created by
+// ChanmapOf can create and index chans. Every chan created by
 // chanmap has a unique id, so you can get them by the id.
 //
-// Multiple goroutines can invoke methods on a Chanmap simultaneously.
-type Chanmap struct {
-	chans       map[ChanID]chan interface{}
+// The chans carry values of type T, use Chanmap (created by
+// NewChanmap) for the untyped case.
+//
+// A chan lives until it is removed, or until it expires if it has
+// a TTL (see SetTTL and NewFT). Expired chans are removed in the
+// background, and OnExpire is called for each of them.
+//
+// Multiple goroutines can invoke methods on a ChanmapOf simultaneously.
+type ChanmapOf[T any] struct {
+	// OnExpire is called (in a separate goroutine) after a chan expired
+	// and has been removed. It must be set before any chan is created.
+	OnExpire func(id ChanID, c chan T)
+
+	chans       map[ChanID]*chanEntry[T]
 	chanLock    sync.RWMutex
 	nextID      ChanID
 	defChanSize int
+	ttl         time.Duration
+	expired     int64
+	removed     int64
 }
 
+type chanEntry[T any] struct {
+	c       chan T
+	created time.Time
+	timer   *time.Timer
+	expired DoneChan // nil if no TTL
+}
+
+// ChanmapStats contains the statistics of a Chanmap.
+type ChanmapStats struct {
+	Live      int           // number of chans in the map
+	Expired   int64         // number of expired chans
+	Removed   int64         // number of removed chans
+	OldestAge time.Duration // age of the oldest chan in the map
+}
+
+// Chanmap is the untyped ChanmapOf.
+type Chanmap = ChanmapOf[interface{}]
+
 func NewChanmap(defChanSize int) *Chanmap {
-	return &Chanmap{
-		chans:       make(map[ChanID]chan interface{}),
+	return NewChanmapOf[interface{}](defChanSize)
+}
+
+func NewChanmapOf[T any](defChanSize int) *ChanmapOf[T] {
+	return &ChanmapOf[T]{
+		chans:       make(map[ChanID]*chanEntry[T]),
 		nextID:      1,
 		defChanSize: defChanSize,
 	}
 }
 
-func (m *Chanmap) New() (id ChanID, c chan interface{}) {
+// SetTTL sets the default TTL of the chans created after it,
+// ttl <= 0 means no TTL.
+func (m *ChanmapOf[T]) SetTTL(ttl time.Duration) {
+	m.chanLock.Lock()
+	defer m.chanLock.Unlock()
+	m.ttl = ttl
+}
+
+func (m *ChanmapOf[T]) New() (id ChanID, c chan T) {
 	return m.NewF(m.defChanSize)
 }
 
-func (m *Chanmap) NewF(size int) (id ChanID, c chan interface{}) {
+func (m *ChanmapOf[T]) NewF(size int) (id ChanID, c chan T) {
 	m.chanLock.Lock()
 	defer m.chanLock.Unlock()
+	return m.newLocked(size, m.ttl)
+}
+
+// NewFT creates a chan with the specified size and TTL,
+// ttl <= 0 means no TTL.
+func (m *ChanmapOf[T]) NewFT(size int, ttl time.Duration) (id ChanID, c chan T) {
+	m.chanLock.Lock()
+	defer m.chanLock.Unlock()
+	return m.newLocked(size, ttl)
+}
+
+func (m *ChanmapOf[T]) newLocked(size int, ttl time.Duration) (id ChanID, c chan T) {
 	id = m.nextID
-	c = make(chan interface{}, size)
-	m.chans[id] = c
+	c = m.addLocked(id, size, ttl).c
 	m.nextID += 1
 	return
 }
 
-func (m *Chanmap) Get(id ChanID) chan interface{} {
+// newExpiring is like NewF, but also returns a DoneChanR which fires
+// when the chan expires, it is nil if the chan has no TTL.
+func (m *ChanmapOf[T]) newExpiring(size int) (id ChanID, c chan T, expired DoneChanR) {
+	m.chanLock.Lock()
+	defer m.chanLock.Unlock()
+	id = m.nextID
+	e := m.addLocked(id, size, m.ttl)
+	m.nextID += 1
+	return id, e.c, e.expired.R()
+}
+
+func (m *ChanmapOf[T]) addLocked(id ChanID, size int, ttl time.Duration) *chanEntry[T] {
+	e := &chanEntry[T]{c: make(chan T, size), created: time.Now()}
+	if ttl > 0 {
+		e.expired = NewDoneChan()
+		e.timer = time.AfterFunc(ttl, func() { m.expire(id, e) })
+	}
+	m.chans[id] = e
+	return e
+}
+
+func (m *ChanmapOf[T]) expire(id ChanID, e *chanEntry[T]) {
+	m.chanLock.Lock()
+	if m.chans[id] != e {
+		m.chanLock.Unlock()
+		return
+	}
+	delete(m.chans, id)
+	m.expired++
+	m.chanLock.Unlock()
+	e.expired.SetDone()
+
+	if m.OnExpire != nil {
+		m.OnExpire(id, e.c)
+	}
+}
+
+func (m *ChanmapOf[T]) Get(id ChanID) chan T {
 	m.chanLock.RLock()
 	defer m.chanLock.RUnlock()
-	return m.chans[id]
+	if e, ok := m.chans[id]; ok {
+		return e.c
+	}
+	return nil
 }
 
-func (m *Chanmap) Remove(id ChanID) {
+func (m *ChanmapOf[T]) Remove(id ChanID) {
 	m.chanLock.Lock()
 	defer m.chanLock.Unlock()
+	m.removeLocked(id)
+}
+
+func (m *ChanmapOf[T]) removeLocked(id ChanID) *chanEntry[T] {
+	e, ok := m.chans[id]
+	if !ok {
+		return nil
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
 	delete(m.chans, id)
+	m.removed++
+	return e
+}
+
+// Len returns the number of chans in the map.
+func (m *ChanmapOf[T]) Len() int {
+	m.chanLock.RLock()
+	defer m.chanLock.RUnlock()
+	return len(m.chans)
+}
+
+// Stats returns the statistics of the map.
+func (m *ChanmapOf[T]) Stats() ChanmapStats {
+	m.chanLock.RLock()
+	defer m.chanLock.RUnlock()
+	st := ChanmapStats{
+		Live:    len(m.chans),
+		Expired: m.expired,
+		Removed: m.removed,
+	}
+	now := time.Now()
+	for _, e := range m.chans {
+		if age := now.Sub(e.created); age > st.OldestAge {
+			st.OldestAge = age
+		}
+	}
+	return st
+}
+
+// Range calls fn for each chan in the map, in no particular order,
+// until fn returns false. The map is locked during the iteration,
+// so fn must not call the methods of m, use Snapshot in that case.
+func (m *ChanmapOf[T]) Range(fn func(id ChanID, c chan T) bool) {
+	m.chanLock.RLock()
+	defer m.chanLock.RUnlock()
+	for id, e := range m.chans {
+		if !fn(id, e.c) {
+			return
+		}
+	}
+}
+
+// Snapshot returns an iterator over a copy of the map, in ascending id
+// order. The map is not locked during the iteration.
+func (m *ChanmapOf[T]) Snapshot() iter.Seq2[ChanID, chan T] {
+	m.chanLock.RLock()
+	ids := make([]ChanID, 0, len(m.chans))
+	cs := make(map[ChanID]chan T, len(m.chans))
+	for id, e := range m.chans {
+		ids = append(ids, id)
+		cs[id] = e.c
+	}
+	m.chanLock.RUnlock()
+	slices.Sort(ids)
+
+	return func(yield func(ChanID, chan T) bool) {
+		for _, id := range ids {
+			if !yield(id, cs[id]) {
+				return
+			}
+		}
+	}
+}
+
+// deliver removes the chan with the id, then sends v to it without blocking.
+func (m *ChanmapOf[T]) deliver(id ChanID, v T) bool {
+	m.chanLock.Lock()
+	defer m.chanLock.Unlock()
+	e := m.removeLocked(id)
+	if e == nil {
+		return false
+	}
+	select {
+	case e.c <- v:
+		return true
+	default:
+		return false
+	}
 }
 
 var defChanmap = NewChanmap(1)
@@ -106,6 +293,29 @@ func (e Event) R() EventR {
 // You can determine whether event setted through EventR.
 type EventR <-chan struct{}
 
+// Auto reset event with a value of type T.
+type TypedEvent[T any] chan T
+
+func NewTypedEvent[T any]() TypedEvent[T] {
+	return make(chan T, 1)
+}
+
+// Set sets the event with v. If the event is setted already,
+// v is discarded.
+func (e TypedEvent[T]) Set(v T) {
+	select {
+	case e <- v:
+	default:
+	}
+}
+
+func (e TypedEvent[T]) R() TypedEventR[T] {
+	return TypedEventR[T]((chan T)(e))
+}
+
+// You can receive the value of the setted event through TypedEventR.
+type TypedEventR[T any] <-chan T
+
 // You can notify something done through DoneChan.SetDone().
 type DoneChan chan struct{}
 
@@ -139,6 +349,9 @@ func (d DoneChanR) Done() bool {
 }
 
 // Semaphore can be used to limit access to multiple resources.
+//
+// Deprecated: Semaphore is not fair and can not be cancelled,
+// use WeightedSemaphore instead.
 type Semaphore chan struct{}
 
 func NewSemaphore(n int) Semaphore {
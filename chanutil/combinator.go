@@ -0,0 +1,271 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// The combinators below build pipelines on chans. Each of them stops
+// after the input is closed or done fires, then closes its output(s).
+// A ctx.Done() can be passed as done directly.
+
+// Merge merges the values of cs into one chan.
+func Merge[T any](done DoneChanR, cs ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	wg.Add(len(cs))
+	for _, c := range cs {
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case v, ok := <-c:
+					if !ok {
+						return
+					}
+					select {
+					case out <- v:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Tee copies every value of in to n chans, a value is sent to all
+// of them before the next one is received.
+func Tee[T any](done DoneChanR, in <-chan T, n int) []<-chan T {
+	if n <= 0 {
+		panic(ErrInvalidArgs)
+	}
+
+	outs := make([]chan T, n)
+	routs := make([]<-chan T, n)
+	for i := range outs {
+		outs[i] = make(chan T)
+		routs[i] = outs[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		for {
+			var v T
+			select {
+			case vv, ok := <-in:
+				if !ok {
+					return
+				}
+				v = vv
+			case <-done:
+				return
+			}
+
+			// Send to whichever is ready, until all of them received v.
+			cases := make([]reflect.SelectCase, n+1)
+			cases[0] = reflect.SelectCase{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(done),
+			}
+			rv := reflect.ValueOf(&v).Elem()
+			for i, out := range outs {
+				cases[i+1] = reflect.SelectCase{
+					Dir:  reflect.SelectSend,
+					Chan: reflect.ValueOf(out),
+					Send: rv,
+				}
+			}
+			for left := n; left > 0; left-- {
+				chosen, _, _ := reflect.Select(cases)
+				if chosen == 0 {
+					return
+				}
+				// A zero Chan is never selected.
+				cases[chosen].Chan = reflect.Value{}
+			}
+		}
+	}()
+	return routs
+}
+
+// Batch groups the values of in into slices. A slice is sent when it
+// has size values, or maxDelay has passed since its first value was
+// received. The remaining values are sent after in is closed.
+func Batch[T any](done DoneChanR, in <-chan T, size int,
+	maxDelay time.Duration) <-chan []T {
+
+	if size <= 0 || maxDelay <= 0 {
+		panic(ErrInvalidArgs)
+	}
+
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+
+		var (
+			batch []T
+			timer *time.Timer
+			timeC <-chan time.Time
+		)
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, timeC = nil, nil
+			}
+			if len(batch) == 0 {
+				return true
+			}
+			select {
+			case out <- batch:
+				batch = nil
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, v)
+				if len(batch) == 1 {
+					timer = time.NewTimer(maxDelay)
+					timeC = timer.C
+				}
+				if len(batch) >= size && !flush() {
+					return
+				}
+			case <-timeC:
+				timer, timeC = nil, nil
+				if !flush() {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Throttle passes the values of in with at least interval between
+// two of them.
+func Throttle[T any](done DoneChanR, in <-chan T,
+	interval time.Duration) <-chan T {
+
+	if interval <= 0 {
+		panic(ErrInvalidArgs)
+	}
+
+	out := make(chan T)
+	go func() {
+		defer close(out)
+
+		var last time.Time
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if wait := interval - time.Since(last); wait > 0 {
+					t := time.NewTimer(wait)
+					select {
+					case <-t.C:
+					case <-done:
+						t.Stop()
+						return
+					}
+				}
+				select {
+				case out <- v:
+					last = time.Now()
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Debounce passes the last value of each burst, a burst ends when no
+// value is received during quiet. The pending value is sent after in
+// is closed.
+func Debounce[T any](done DoneChanR, in <-chan T,
+	quiet time.Duration) <-chan T {
+
+	if quiet <= 0 {
+		panic(ErrInvalidArgs)
+	}
+
+	out := make(chan T)
+	go func() {
+		defer close(out)
+
+		var (
+			last    T
+			pending bool
+			timer   = time.NewTimer(quiet)
+		)
+		timer.Stop()
+		defer timer.Stop()
+
+		emit := func() bool {
+			if !pending {
+				return true
+			}
+			select {
+			case out <- last:
+				pending = false
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					emit()
+					return
+				}
+				last, pending = v, true
+				timer.Reset(quiet)
+			case <-timer.C:
+				if !emit() {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
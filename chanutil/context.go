@@ -0,0 +1,92 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"golang.org/x/net/context"
+)
+
+// DoneContext returns a context derived from parent, it is cancelled
+// when d fires, parent is done or cancel is called.
+func DoneContext(parent context.Context,
+	d DoneChanR) (context.Context, context.CancelFunc) {
+
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-d:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// EventContext returns a context derived from parent, it is cancelled
+// when e is setted (the event is consumed), parent is done or cancel
+// is called.
+func EventContext(parent context.Context,
+	e EventR) (context.Context, context.CancelFunc) {
+
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-e:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// ContextDone returns a DoneChanR which fires when ctx is done.
+func ContextDone(ctx context.Context) DoneChanR {
+	return DoneChanR(ctx.Done())
+}
+
+// ContextDoneChan returns a DoneChan which is closed when ctx is done,
+// it can also be closed earlier through SetDone.
+func ContextDoneChan(ctx context.Context) DoneChan {
+	d := NewDoneChan()
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.SetDone()
+		case <-d:
+		}
+	}()
+	return d
+}
+
+// MergeContexts returns a context which is cancelled when either a
+// or b is done, or cancel is called. Its values come from a (the
+// primary one), its deadline is the earlier one of a and b.
+func MergeContexts(a, b context.Context) (context.Context, context.CancelFunc) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	bdl, bok := b.Deadline()
+	adl, aok := a.Deadline()
+	useBDeadline := bok && (!aok || bdl.Before(adl))
+	if useBDeadline {
+		ctx, cancel = context.WithDeadline(a, bdl)
+	} else {
+		ctx, cancel = context.WithCancel(a)
+	}
+
+	go func() {
+		select {
+		case <-b.Done():
+			// Let the same deadline expire by itself, so ctx.Err()
+			// reports DeadlineExceeded too.
+			if !useBDeadline || b.Err() != context.DeadlineExceeded {
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
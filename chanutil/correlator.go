@@ -0,0 +1,97 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"sync/atomic"
+)
+
+// ErrCallExpired is returned by the wait function of a Correlator call
+// if its chan expired (see Chanmap.SetTTL) before the reply.
+var ErrCallExpired = errors.New("call expired")
+
+// Correlator matches async replies to callers by ChanID, it is a
+// correlation layer on top of Chanmap (or ShardedChanmap).
+//
+// The caller registers itself through Call, sends the id along with
+// its request, then waits the reply. The replier passes the reply
+// to Deliver with the same id. The chan entry is removed when the call
+// is replied, times out, is cancelled, expires, or when the caller
+// returns (through the cancel function), and a late delivery is dropped
+// and counted.
+//
+// Multiple goroutines can invoke methods on a Correlator simultaneously.
+type Correlator struct {
+	m       ChanIndex[interface{}]
+	dropped int64
+}
+
+// If m is nil, a new Chanmap is used.
+func NewCorrelator(m ChanIndex[interface{}]) *Correlator {
+	if m == nil {
+		m = NewChanmap(1)
+	}
+	return &Correlator{m: m}
+}
+
+// Call registers a new call, returns its id, the wait function and
+// the cancel function.
+//
+// The wait function blocks until the reply is delivered, ctx is done
+// or the chan expires, it can be called at most once.
+//
+// The cancel function removes the call, the caller must call it
+// (typically deferred) when it returns, whether or not wait is called,
+// for example if sending the request failed. It can be called multiple
+// times.
+func (c *Correlator) Call(ctx context.Context) (id ChanID,
+	wait func() (interface{}, error), cancel func()) {
+
+	// At least one buffer, so Deliver never blocks.
+	id, ch, expired := c.m.newExpiring(1)
+
+	wait = func() (interface{}, error) {
+		var err error
+		select {
+		case v := <-ch:
+			return v, nil
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-expired:
+			err = ErrCallExpired
+		}
+
+		c.m.Remove(id)
+		// The reply may arrive before the remove.
+		select {
+		case v := <-ch:
+			return v, nil
+		default:
+			return nil, err
+		}
+	}
+	cancel = func() {
+		c.m.Remove(id)
+	}
+	return
+}
+
+// Deliver passes the reply v to the call with the id, it never blocks.
+// If the call is not found (unknown, returned or delivered already),
+// v is dropped and false is returned.
+func (c *Correlator) Deliver(id ChanID, v interface{}) bool {
+	if !c.m.deliver(id, v) {
+		atomic.AddInt64(&c.dropped, 1)
+		return false
+	}
+	return true
+}
+
+// Dropped returns the count of dropped deliveries.
+func (c *Correlator) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestCorrelator(t *testing.T) {
+	c := NewCorrelator(nil)
+	id, wait, cancel := c.Call(context.Background())
+	defer cancel()
+	go c.Deliver(id, 5)
+	if v, err := wait(); v != 5 || err != nil {
+		t.Fatalf("got %v, %v", v, err)
+	}
+
+	// A late delivery is dropped.
+	if c.Deliver(id, 6) || c.Dropped() != 1 {
+		t.Fatalf("late delivery accepted, dropped %d", c.Dropped())
+	}
+}
+
+func TestCorrelatorTimeout(t *testing.T) {
+	m := NewChanmap(1)
+	c := NewCorrelator(m)
+	ctx, ctxCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer ctxCancel()
+	id, wait, _ := c.Call(ctx)
+	if _, err := wait(); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	if m.Get(id) != nil {
+		t.Fatal("entry left after timeout")
+	}
+}
+
+func TestCorrelatorCancelWithoutWait(t *testing.T) {
+	m := NewChanmap(1)
+	c := NewCorrelator(m)
+	_, _, cancel := c.Call(context.Background())
+	cancel()
+	cancel()
+	if m.Len() != 0 {
+		t.Fatalf("%d entries left after cancel", m.Len())
+	}
+}
+
+func TestCorrelatorExpire(t *testing.T) {
+	for _, m := range []ChanIndex[interface{}]{NewChanmap(1), NewShardedChanmap(4, 1)} {
+		m.SetTTL(10 * time.Millisecond)
+		c := NewCorrelator(m)
+		_, wait, cancel := c.Call(context.Background())
+		if _, err := wait(); err != ErrCallExpired {
+			t.Fatalf("%T: got %v, want ErrCallExpired", m, err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ElasticChan is an unbounded chan, the values sent to In() are kept
+// in a growable buffer until they are received from Out(), so the
+// senders never block.
+//
+// The buffer can be bounded by a hard cap, in that case the policy
+// (SlowDropNewest or SlowDropOldest) decides which value is discarded
+// when the buffer is full.
+//
+// After Close (or closing In() directly), the buffered values are
+// still delivered in order, then Out() is closed.
+type ElasticChan[T any] struct {
+	in  chan T
+	out chan T
+
+	highWater   int
+	onHighWater func(n int)
+	hardCap     int
+	policy      SlowPolicy
+
+	closeOnce sync.Once
+	buffered  int64
+	dropped   int64
+}
+
+// NewElasticChan creates an ElasticChan.
+//
+// If highWater > 0, onHighWater is called each time the number of
+// buffered values rises to highWater, it is called in the internal
+// goroutine, so it must not block.
+//
+// If hardCap > 0, the buffer is bounded by it, and policy must be
+// SlowDropNewest or SlowDropOldest.
+func NewElasticChan[T any](highWater int, onHighWater func(n int),
+	hardCap int, policy SlowPolicy) *ElasticChan[T] {
+
+	if hardCap > 0 && policy != SlowDropNewest && policy != SlowDropOldest {
+		panic(ErrInvalidArgs)
+	}
+
+	c := &ElasticChan[T]{
+		in:          make(chan T),
+		out:         make(chan T),
+		highWater:   highWater,
+		onHighWater: onHighWater,
+		hardCap:     hardCap,
+		policy:      policy,
+	}
+	go c.loop()
+	return c
+}
+
+func (c *ElasticChan[T]) In() chan<- T {
+	return c.in
+}
+
+func (c *ElasticChan[T]) Out() <-chan T {
+	return c.out
+}
+
+// Close closes In(), the buffered values are still delivered.
+func (c *ElasticChan[T]) Close() error {
+	c.closeOnce.Do(func() { close(c.in) })
+	return nil
+}
+
+// Len returns the number of buffered values.
+func (c *ElasticChan[T]) Len() int {
+	return int(atomic.LoadInt64(&c.buffered))
+}
+
+// Dropped returns the number of values discarded because of the hard cap.
+func (c *ElasticChan[T]) Dropped() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+func (c *ElasticChan[T]) loop() {
+	defer close(c.out)
+
+	var buf ring[T]
+	in := c.in
+	for in != nil || buf.len() > 0 {
+		var (
+			out  chan T
+			next T
+		)
+		if buf.len() > 0 {
+			out = c.out
+			next = buf.peek()
+		}
+
+		select {
+		case v, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			c.push(&buf, v)
+		case out <- next:
+			buf.pop()
+		}
+		atomic.StoreInt64(&c.buffered, int64(buf.len()))
+	}
+}
+
+func (c *ElasticChan[T]) push(buf *ring[T], v T) {
+	if c.hardCap > 0 && buf.len() >= c.hardCap {
+		atomic.AddInt64(&c.dropped, 1)
+		if c.policy == SlowDropNewest {
+			return
+		}
+		buf.pop()
+	}
+
+	buf.push(v)
+	if c.highWater > 0 && buf.len() == c.highWater && c.onHighWater != nil {
+		c.onHighWater(buf.len())
+	}
+}
+
+// ring is a growable FIFO ring buffer.
+type ring[T any] struct {
+	items []T
+	head  int
+	n     int
+}
+
+func (r *ring[T]) len() int {
+	return r.n
+}
+
+func (r *ring[T]) push(v T) {
+	if r.n == len(r.items) {
+		r.grow()
+	}
+	r.items[(r.head+r.n)%len(r.items)] = v
+	r.n++
+}
+
+func (r *ring[T]) peek() T {
+	return r.items[r.head]
+}
+
+func (r *ring[T]) pop() T {
+	var zero T
+	v := r.items[r.head]
+	r.items[r.head] = zero
+	r.head = (r.head + 1) % len(r.items)
+	r.n--
+	if r.n == 0 {
+		r.head = 0
+	}
+	return v
+}
+
+// at returns the i-th item from the head.
+func (r *ring[T]) at(i int) T {
+	return r.items[(r.head+i)%len(r.items)]
+}
+
+func (r *ring[T]) popBack() T {
+	var zero T
+	i := (r.head + r.n - 1) % len(r.items)
+	v := r.items[i]
+	r.items[i] = zero
+	r.n--
+	if r.n == 0 {
+		r.head = 0
+	}
+	return v
+}
+
+func (r *ring[T]) grow() {
+	size := len(r.items) * 2
+	if size == 0 {
+		size = 16
+	}
+	items := make([]T, size)
+	for i := 0; i < r.n; i++ {
+		items[i] = r.items[(r.head+i)%len(r.items)]
+	}
+	r.items = items
+	r.head = 0
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"golang.org/x/net/context"
+	"runtime/debug"
+	"sync"
+)
+
+// FlightGroup deduplicates the calls by key, there is at most one
+// in-flight call for each key, the callers of the same key share
+// its result.
+//
+// Multiple goroutines can invoke methods on a FlightGroup simultaneously.
+type FlightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done DoneChan
+	v    interface{}
+	err  error
+	dups int
+}
+
+func NewFlightGroup() *FlightGroup {
+	return &FlightGroup{calls: make(map[string]*flightCall)}
+}
+
+// Do runs fn for the key if there is no in-flight call of the key,
+// otherwise joins the in-flight one, then waits until the call returns
+// or ctx is done. shared reports whether the result is shared with
+// other callers.
+//
+// fn runs in a separate goroutine, it is not cancelled when the callers
+// leave, a panic in fn is turned into a *PanicError.
+func (g *FlightGroup) Do(ctx context.Context, key string,
+	fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+
+	g.lock.Lock()
+	c, ok := g.calls[key]
+	if ok {
+		c.dups++
+	} else {
+		c = &flightCall{done: NewDoneChan()}
+		g.calls[key] = c
+		go g.call(key, c, fn)
+	}
+	g.lock.Unlock()
+
+	select {
+	case <-c.done:
+		g.lock.Lock()
+		shared = c.dups > 0
+		g.lock.Unlock()
+		return c.v, c.err, shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), ok
+	}
+}
+
+func (g *FlightGroup) call(key string, c *flightCall,
+	fn func() (interface{}, error)) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			c.err = &PanicError{Value: e, Stack: debug.Stack()}
+		}
+
+		g.lock.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.lock.Unlock()
+		c.done.SetDone()
+	}()
+
+	c.v, c.err = fn()
+}
+
+// Forget forgets the in-flight call of the key, the later Do of the
+// key will start a new call instead of joining it.
+func (g *FlightGroup) Forget(key string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.calls, key)
+}
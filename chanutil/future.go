@@ -0,0 +1,189 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// Future is a placeholder of a value (or an error) of type T which
+// will be available later. It is settled once, by Resolve or Reject,
+// the later settlements are ignored.
+//
+// Multiple goroutines can invoke methods on a Future simultaneously.
+type Future[T any] struct {
+	once sync.Once
+	done DoneChan
+	v    T
+	err  error
+}
+
+func NewFuture[T any]() *Future[T] {
+	return &Future[T]{done: NewDoneChan()}
+}
+
+// Async runs fn in a new goroutine, the returned future is settled
+// with the result of fn.
+func Async[T any](fn func() (T, error)) *Future[T] {
+	f := NewFuture[T]()
+	go func() {
+		v, err := fn()
+		f.settle(v, err)
+	}()
+	return f
+}
+
+// Resolve settles the future with the value v, it returns false
+// if the future is settled already.
+func (f *Future[T]) Resolve(v T) bool {
+	return f.settle(v, nil)
+}
+
+// Reject settles the future with the error err, it returns false
+// if the future is settled already. err must not be nil.
+func (f *Future[T]) Reject(err error) bool {
+	if err == nil {
+		panic(ErrInvalidArgs)
+	}
+	var zero T
+	return f.settle(zero, err)
+}
+
+func (f *Future[T]) settle(v T, err error) bool {
+	settled := false
+	f.once.Do(func() {
+		f.v, f.err = v, err
+		f.done.SetDone()
+		settled = true
+	})
+	return settled
+}
+
+// Get waits until the future is settled or ctx is done.
+func (f *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.v, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Done is fired after the future is settled.
+func (f *Future[T]) Done() DoneChanR {
+	return f.done.R()
+}
+
+// result must be called after the future is settled.
+func (f *Future[T]) result() (T, error) {
+	return f.v, f.err
+}
+
+// Then returns a future settled with fn(v) after f is resolved with v,
+// or rejected with the same error after f is rejected.
+func Then[T, U any](f *Future[T], fn func(T) (U, error)) *Future[U] {
+	r := NewFuture[U]()
+	go func() {
+		<-f.done
+		v, err := f.result()
+		if err != nil {
+			var zero U
+			r.settle(zero, err)
+			return
+		}
+		r.settle(fn(v))
+	}()
+	return r
+}
+
+// All returns a future resolved with all the values (in the order of fs)
+// after all of fs are resolved, or rejected with the first error.
+func All[T any](fs ...*Future[T]) *Future[[]T] {
+	r := NewFuture[[]T]()
+	vs := make([]T, len(fs))
+
+	var wg sync.WaitGroup
+	wg.Add(len(fs))
+	for i, f := range fs {
+		go func() {
+			defer wg.Done()
+			select {
+			case <-f.done:
+			case <-r.done:
+				return
+			}
+			v, err := f.result()
+			if err != nil {
+				r.Reject(err)
+				return
+			}
+			vs[i] = v
+		}()
+	}
+	go func() {
+		wg.Wait()
+		r.Resolve(vs)
+	}()
+	return r
+}
+
+// Any returns a future resolved with the first resolved value of fs,
+// or rejected with all the errors after all of fs are rejected.
+// If fs is empty, the future is rejected with ErrInvalidArgs.
+func Any[T any](fs ...*Future[T]) *Future[T] {
+	r := NewFuture[T]()
+	if len(fs) == 0 {
+		r.Reject(ErrInvalidArgs)
+		return r
+	}
+	errs := make([]error, len(fs))
+
+	var wg sync.WaitGroup
+	wg.Add(len(fs))
+	for i, f := range fs {
+		go func() {
+			defer wg.Done()
+			select {
+			case <-f.done:
+			case <-r.done:
+				return
+			}
+			v, err := f.result()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			r.Resolve(v)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		// If one of fs is resolved, r is settled already and the
+		// errors may be all nil.
+		if err := errors.Join(errs...); err != nil {
+			r.Reject(err)
+		}
+	}()
+	return r
+}
+
+// Race returns a future settled the same as the first settled one of fs.
+// If fs is empty, the future is never settled.
+func Race[T any](fs ...*Future[T]) *Future[T] {
+	r := NewFuture[T]()
+	for _, f := range fs {
+		go func() {
+			select {
+			case <-f.done:
+				r.settle(f.result())
+			case <-r.done:
+			}
+		}()
+	}
+	return r
+}
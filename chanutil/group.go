@@ -0,0 +1,144 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError is the error turned from a panic.
+type PanicError struct {
+	Value interface{} // the recovered value
+	Stack []byte      // the stack where the panic occurred
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Group runs a group of related goroutines. The first error (or panic)
+// cancels the group context, and is returned by Wait.
+//
+// Multiple goroutines can invoke methods on a Group simultaneously.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   DoneChan
+	wg     sync.WaitGroup
+
+	lock sync.Mutex
+	sema *WeightedSemaphore // nil if no limit
+	err  error
+}
+
+// NewGroup creates a Group, its context is derived from ctx.
+func NewGroup(ctx context.Context) *Group {
+	g := &Group{done: NewDoneChan()}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+	return g
+}
+
+// Context returns the group context, it is cancelled after the first
+// error, or after Wait returns.
+func (g *Group) Context() context.Context {
+	return g.ctx
+}
+
+// Done is fired after the first error.
+func (g *Group) Done() DoneChanR {
+	return g.done.R()
+}
+
+// SetLimit limits the number of active goroutines to n,
+// n <= 0 means no limit. The limit can be changed at any time,
+// a lowered limit takes effect as the active ones return.
+func (g *Group) SetLimit(n int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if n <= 0 {
+		g.sema = nil
+		return
+	}
+	if g.sema == nil {
+		g.sema = NewWeightedSemaphore(n)
+		return
+	}
+	g.sema.SetLimit(n)
+}
+
+// Go calls fn with the group context in a new goroutine. If the limit
+// is reached, it blocks until an active goroutine returns. If the group
+// context is done before that, fn is not called, and the context error
+// is recorded as the group error (unless there is an earlier one).
+func (g *Group) Go(fn func(ctx context.Context) error) {
+	g.lock.Lock()
+	sema := g.sema
+	g.lock.Unlock()
+
+	if sema != nil {
+		if err := sema.AcquireCtx(g.ctx, 1); err != nil {
+			g.fail(err)
+			return
+		}
+	}
+	g.start(sema, fn)
+}
+
+// TryGo is like Go, but it returns false instead of blocking
+// if the limit is reached.
+func (g *Group) TryGo(fn func(ctx context.Context) error) bool {
+	g.lock.Lock()
+	sema := g.sema
+	g.lock.Unlock()
+
+	if sema != nil && !sema.TryAcquire(1) {
+		return false
+	}
+	g.start(sema, fn)
+	return true
+}
+
+func (g *Group) start(sema *WeightedSemaphore, fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if sema != nil {
+			defer sema.Release(1)
+		}
+		defer func() {
+			if e := recover(); e != nil {
+				g.fail(&PanicError{Value: e, Stack: debug.Stack()})
+			}
+		}()
+
+		if err := fn(g.ctx); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+func (g *Group) fail(err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.err == nil {
+		g.err = err
+		g.cancel()
+		g.done.SetDone()
+	}
+}
+
+// Wait waits until all the goroutines return, then returns
+// the first error.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.err
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupLimit(t *testing.T) {
+	g := NewGroup(context.Background())
+	g.SetLimit(2)
+	var active, max int64
+	for i := 0; i < 20; i++ {
+		g.Go(func(ctx context.Context) error {
+			n := atomic.AddInt64(&active, 1)
+			for {
+				m := atomic.LoadInt64(&max)
+				if n <= m || atomic.CompareAndSwapInt64(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&active, -1)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if max > 2 {
+		t.Fatalf("%d active goroutines, limit 2", max)
+	}
+}
+
+func TestGroupFirstError(t *testing.T) {
+	e := errors.New("failed")
+	g := NewGroup(context.Background())
+	g.Go(func(ctx context.Context) error { return e })
+	g.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := g.Wait(); err != e {
+		t.Fatalf("got %v, want %v", err, e)
+	}
+	if !g.Done().Done() {
+		t.Fatal("Done not fired")
+	}
+}
+
+func TestGroupPanic(t *testing.T) {
+	g := NewGroup(context.Background())
+	g.Go(func(ctx context.Context) error { panic("boom") })
+	var pe *PanicError
+	if err := g.Wait(); !errors.As(err, &pe) || pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Fatalf("got %v, want PanicError", err)
+	}
+}
+
+func TestGroupDroppedByCancel(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	g := NewGroup(parent)
+	g.SetLimit(1)
+	block := make(chan struct{})
+	g.Go(func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	// Blocks on the limit until the parent is cancelled, fn is dropped.
+	var ran int32
+	g.Go(func(ctx context.Context) error {
+		atomic.StoreInt32(&ran, 1)
+		return nil
+	})
+	close(block)
+	if err := g.Wait(); err != context.Canceled {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Fatal("dropped fn ran")
+	}
+}
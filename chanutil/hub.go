@@ -0,0 +1,238 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"golang.org/x/net/context"
+	"strings"
+	"sync"
+)
+
+// SlowPolicy defines what to do when a subscriber's buffer is full.
+type SlowPolicy int
+
+const (
+	// Block the publisher until the subscriber has room.
+	SlowBlock SlowPolicy = iota
+	// Discard the value being published.
+	SlowDropNewest
+	// Discard the oldest value in the buffer.
+	SlowDropOldest
+	// Unsubscribe the subscriber, its chan will be closed.
+	SlowDisconnect
+)
+
+// Subscription is the chan where a subscriber receives the published
+// values, it is closed after the subscriber is unsubscribed.
+type Subscription <-chan interface{}
+
+// Hub is a topic-based publish/subscribe hub.
+//
+// Topics are dot-separated words, such as "user.login". A subscribed
+// topic can contain wildcards: "*" matches exactly one word, "#" matches
+// zero or more words. For example "user.*" matches "user.login" but not
+// "user", "user.#" matches both.
+//
+// Multiple goroutines can invoke methods on a Hub simultaneously.
+type Hub struct {
+	subsLock sync.RWMutex
+	subs     map[Subscription]*subscriber
+	closed   bool
+}
+
+type subscriber struct {
+	pattern []string
+	policy  SlowPolicy
+	c       chan interface{}
+	quit    DoneChan
+
+	lock   sync.Mutex // protects c and closed
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[Subscription]*subscriber)}
+}
+
+// Subscribe subscribes the topic with SlowBlock policy.
+func (h *Hub) Subscribe(topic string, bufSize int) Subscription {
+	return h.SubscribeEx(topic, bufSize, SlowBlock, nil)
+}
+
+// SubscribeEx subscribes the topic with the specified policy.
+// If done is not nil, the subscription is closed after done fires.
+func (h *Hub) SubscribeEx(topic string, bufSize int,
+	policy SlowPolicy, done DoneChanR) Subscription {
+
+	if bufSize < 0 || policy < SlowBlock || policy > SlowDisconnect {
+		panic(ErrInvalidArgs)
+	}
+
+	sub := &subscriber{
+		pattern: strings.Split(topic, "."),
+		policy:  policy,
+		c:       make(chan interface{}, bufSize),
+		quit:    NewDoneChan(),
+	}
+	s := Subscription(sub.c)
+
+	h.subsLock.Lock()
+	if h.closed {
+		h.subsLock.Unlock()
+		sub.close()
+		return s
+	}
+	h.subs[s] = sub
+	h.subsLock.Unlock()
+
+	if done != nil {
+		go func() {
+			select {
+			case <-done:
+				h.Unsubscribe(s)
+			case <-sub.quit:
+			}
+		}()
+	}
+	return s
+}
+
+// SubscribeCtx subscribes the topic with the specified policy,
+// the subscription is closed after ctx is done.
+func (h *Hub) SubscribeCtx(ctx context.Context, topic string, bufSize int,
+	policy SlowPolicy) Subscription {
+
+	return h.SubscribeEx(topic, bufSize, policy, DoneChanR(ctx.Done()))
+}
+
+// Unsubscribe removes the subscription and closes it.
+func (h *Hub) Unsubscribe(s Subscription) {
+	h.subsLock.Lock()
+	sub, ok := h.subs[s]
+	delete(h.subs, s)
+	h.subsLock.Unlock()
+
+	if ok {
+		sub.close()
+	}
+}
+
+// Publish publishes v to the subscribers of the topic,
+// returns the number of subscribers which accepted v.
+func (h *Hub) Publish(topic string, v interface{}) int {
+	words := strings.Split(topic, ".")
+
+	var matched []*subscriber
+	h.subsLock.RLock()
+	for _, sub := range h.subs {
+		if matchTopic(sub.pattern, words) {
+			matched = append(matched, sub)
+		}
+	}
+	h.subsLock.RUnlock()
+
+	n := 0
+	for _, sub := range matched {
+		ok, disconnect := sub.send(v)
+		if ok {
+			n++
+		}
+		if disconnect {
+			h.Unsubscribe(Subscription(sub.c))
+		}
+	}
+	return n
+}
+
+// Close unsubscribes all the subscriptions, the later
+// subscriptions will be closed immediately.
+func (h *Hub) Close() error {
+	h.subsLock.Lock()
+	subs := h.subs
+	h.subs = make(map[Subscription]*subscriber)
+	h.closed = true
+	h.subsLock.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+	return nil
+}
+
+func (sub *subscriber) send(v interface{}) (ok, disconnect bool) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if sub.closed {
+		return false, false
+	}
+
+	select {
+	case sub.c <- v:
+		return true, false
+	default:
+	}
+
+	switch sub.policy {
+	case SlowBlock:
+		select {
+		case sub.c <- v:
+			return true, false
+		case <-sub.quit:
+			return false, false
+		}
+	case SlowDropOldest:
+		if cap(sub.c) == 0 {
+			return false, false
+		}
+		for {
+			select {
+			case sub.c <- v:
+				return true, false
+			default:
+			}
+			select {
+			case <-sub.c:
+			default:
+			}
+		}
+	case SlowDisconnect:
+		return false, true
+	}
+	// SlowDropNewest
+	return false, false
+}
+
+func (sub *subscriber) close() {
+	// Abort the blocked send first.
+	sub.quit.SetDone()
+
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.c)
+	}
+}
+
+func matchTopic(pattern, words []string) bool {
+	for i, p := range pattern {
+		if p == "#" {
+			rest := pattern[i+1:]
+			for j := i; j <= len(words); j++ {
+				if matchTopic(rest, words[j:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if i >= len(words) {
+			return false
+		}
+		if p != "*" && p != words[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(words)
+}
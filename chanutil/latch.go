@@ -0,0 +1,211 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// CountDownLatch allows goroutines to wait until a count of
+// operations are done, the count is decreased through CountDown.
+//
+// Multiple goroutines can invoke methods on a CountDownLatch simultaneously.
+type CountDownLatch struct {
+	lock  sync.Mutex
+	count int
+	done  DoneChan
+}
+
+func NewCountDownLatch(n int) *CountDownLatch {
+	if n < 0 {
+		panic(ErrInvalidArgs)
+	}
+	l := &CountDownLatch{count: n, done: NewDoneChan()}
+	if n == 0 {
+		l.done.SetDone()
+	}
+	return l
+}
+
+// CountDown decreases the count, the waiters are released
+// when the count reaches zero.
+func (l *CountDownLatch) CountDown() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.count == 0 {
+		return
+	}
+	l.count--
+	if l.count == 0 {
+		l.done.SetDone()
+	}
+}
+
+// Count returns the current count.
+func (l *CountDownLatch) Count() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.count
+}
+
+// Wait waits until the count reaches zero or ctx is done.
+func (l *CountDownLatch) Wait(ctx context.Context) error {
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// R returns a DoneChanR which is fired when the count reaches zero.
+func (l *CountDownLatch) R() DoneChanR {
+	return l.done.R()
+}
+
+// Barrier allows a fixed number of goroutines to wait for each other
+// at a common point. It is cyclic, it can be reused after the waiting
+// goroutines are released.
+//
+// Multiple goroutines can invoke methods on a Barrier simultaneously.
+type Barrier struct {
+	lock    sync.Mutex
+	parties int
+	count   int
+	gen     DoneChan // current generation, fired when tripped
+}
+
+func NewBarrier(parties int) *Barrier {
+	if parties <= 0 {
+		panic(ErrInvalidArgs)
+	}
+	return &Barrier{parties: parties, gen: NewDoneChan()}
+}
+
+// Await waits until all parties have called Await on this barrier,
+// or ctx is done. In the latter case, the caller leaves the barrier
+// and is not counted.
+func (b *Barrier) Await(ctx context.Context) error {
+	b.lock.Lock()
+	gen := b.gen
+	b.count++
+	if b.count == b.parties {
+		b.count = 0
+		b.gen = NewDoneChan()
+		b.lock.Unlock()
+		gen.SetDone()
+		return nil
+	}
+	b.lock.Unlock()
+
+	select {
+	case <-gen:
+		return nil
+	case <-ctx.Done():
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if gen.R().Done() {
+		// Tripped before we leave.
+		return nil
+	}
+	b.count--
+	return ctx.Err()
+}
+
+// Parties returns the number of parties required to trip the barrier.
+func (b *Barrier) Parties() int {
+	return b.parties
+}
+
+// Waiting returns the number of parties currently waiting.
+func (b *Barrier) Waiting() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.count
+}
+
+// Manual reset event, it stays setted until Reset is called.
+//
+// Multiple goroutines can invoke methods on a ManualResetEvent simultaneously.
+type ManualResetEvent struct {
+	lock sync.Mutex
+	c    chan struct{} // closed while setted
+	set  bool
+}
+
+func NewManualResetEvent(set bool) *ManualResetEvent {
+	e := &ManualResetEvent{c: make(chan struct{})}
+	if set {
+		e.Set()
+	}
+	return e
+}
+
+func (e *ManualResetEvent) Set() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.set {
+		e.set = true
+		close(e.c)
+	}
+}
+
+func (e *ManualResetEvent) Reset() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.set {
+		e.set = false
+		e.c = make(chan struct{})
+	}
+}
+
+func (e *ManualResetEvent) IsSet() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.set
+}
+
+// C returns a chan which is closed while the event is setted.
+// The chan is replaced after Reset, so call C again to wait
+// the next Set.
+func (e *ManualResetEvent) C() <-chan struct{} {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.c
+}
+
+// Wait waits until the event is setted or ctx is done.
+func (e *ManualResetEvent) Wait(ctx context.Context) error {
+	select {
+	case <-e.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *ManualResetEvent) R() ManualResetEventR {
+	return ManualResetEventR{e}
+}
+
+// You can determine whether event setted through ManualResetEventR.
+type ManualResetEventR struct {
+	e *ManualResetEvent
+}
+
+func (r ManualResetEventR) IsSet() bool {
+	return r.e.IsSet()
+}
+
+func (r ManualResetEventR) C() <-chan struct{} {
+	return r.e.C()
+}
+
+func (r ManualResetEventR) Wait(ctx context.Context) error {
+	return r.e.Wait(ctx)
+}
@@ -0,0 +1,351 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// Limiter controls how frequently events are allowed to happen.
+//
+// Multiple goroutines can invoke methods on a Limiter simultaneously.
+type Limiter interface {
+	// Allow reports whether an event may happen now, the event is
+	// counted if it is allowed.
+	Allow() bool
+	// Reserve reserves an event, the caller should wait
+	// Reservation.Delay() before the event happens.
+	Reserve() *Reservation
+	// Wait waits until an event may happen or ctx is done.
+	Wait(ctx context.Context) error
+}
+
+// Reservation holds an event reserved by a Limiter.
+type Reservation struct {
+	ok     bool
+	at     time.Time
+	cancel func(at time.Time)
+	once   sync.Once
+}
+
+// OK reports whether the event can ever happen, if it is false,
+// the event is not reserved.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long the caller should wait before the event happens.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return time.Duration(math.MaxInt64)
+	}
+	if d := time.Until(r.at); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Cancel gives the reserved event back to the limiter, as far as possible.
+func (r *Reservation) Cancel() {
+	if !r.ok || r.cancel == nil {
+		return
+	}
+	r.once.Do(func() { r.cancel(r.at) })
+}
+
+func waitReservation(ctx context.Context, r *Reservation) error {
+	if !r.ok {
+		return ErrRateLimited
+	}
+	d := r.Delay()
+	if d == 0 {
+		return nil
+	}
+	if dl, ok := ctx.Deadline(); ok && dl.Before(r.at) {
+		r.Cancel()
+		return ErrRateLimited
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// TokenBucket is a Limiter which refills rate tokens per second into
+// a bucket of burst tokens, an event takes one token.
+type TokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a TokenBucket which is full initially.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate < 0 || burst <= 0 {
+		panic(ErrInvalidArgs)
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// SetRate changes the rate and burst, the tokens in the bucket are kept.
+func (b *TokenBucket) SetRate(rate float64, burst int) {
+	if rate < 0 || burst <= 0 {
+		panic(ErrInvalidArgs)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance(time.Now())
+	b.rate = rate
+	b.burst = burst
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+}
+
+// Must be called with b.lock held.
+func (b *TokenBucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > float64(b.burst) {
+			b.tokens = float64(b.burst)
+		}
+		b.last = now
+	}
+}
+
+func (b *TokenBucket) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance(time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+func (b *TokenBucket) Reserve() *Reservation {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	b.advance(now)
+	if b.tokens < 1 && b.rate == 0 {
+		return &Reservation{}
+	}
+
+	b.tokens--
+	at := now
+	if b.tokens < 0 {
+		at = now.Add(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+	}
+	return &Reservation{ok: true, at: at, cancel: b.cancel}
+}
+
+func (b *TokenBucket) cancel(at time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	if !at.After(now) {
+		// Used already.
+		return
+	}
+	b.advance(now)
+	b.tokens++
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	return waitReservation(ctx, b.Reserve())
+}
+
+// SlidingWindow is a Limiter which allows at most limit events
+// in any window of time.
+type SlidingWindow struct {
+	lock   sync.Mutex
+	limit  int
+	window time.Duration
+	events ring[time.Time] // event times in ascending order
+}
+
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	if limit <= 0 || window <= 0 {
+		panic(ErrInvalidArgs)
+	}
+	return &SlidingWindow{limit: limit, window: window}
+}
+
+// SetRate changes the limit and window, the recorded events are kept.
+func (w *SlidingWindow) SetRate(limit int, window time.Duration) {
+	if limit <= 0 || window <= 0 {
+		panic(ErrInvalidArgs)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.limit = limit
+	w.window = window
+}
+
+// Must be called with w.lock held.
+func (w *SlidingWindow) expire(now time.Time) {
+	for w.events.len() > 0 && !w.events.peek().Add(w.window).After(now) {
+		w.events.pop()
+	}
+}
+
+// next returns the earliest time a new event may happen.
+// Must be called with w.lock held.
+func (w *SlidingWindow) next(now time.Time) time.Time {
+	n := w.events.len()
+	if n < w.limit {
+		if n > 0 && w.events.at(n-1).After(now) {
+			// Keep the order of the reserved events.
+			return w.events.at(n - 1)
+		}
+		return now
+	}
+	return w.events.at(n - w.limit).Add(w.window)
+}
+
+func (w *SlidingWindow) Allow() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	now := time.Now()
+	w.expire(now)
+	if w.next(now).After(now) {
+		return false
+	}
+	w.events.push(now)
+	return true
+}
+
+func (w *SlidingWindow) Reserve() *Reservation {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	now := time.Now()
+	w.expire(now)
+	at := w.next(now)
+	w.events.push(at)
+	return &Reservation{ok: true, at: at, cancel: w.cancel}
+}
+
+func (w *SlidingWindow) cancel(at time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !at.After(time.Now()) {
+		// Used already.
+		return
+	}
+	// Only the last reserved one can be removed without reordering.
+	if n := w.events.len(); n > 0 && w.events.at(n-1).Equal(at) {
+		w.events.popBack()
+	}
+}
+
+func (w *SlidingWindow) Wait(ctx context.Context) error {
+	return waitReservation(ctx, w.Reserve())
+}
+
+// KeyedLimiter keeps a Limiter for each key, such as a client address
+// or a host. The limiters which have been idle longer than idle are
+// evicted.
+//
+// Multiple goroutines can invoke methods on a KeyedLimiter simultaneously.
+type KeyedLimiter struct {
+	newFn func(key string) Limiter
+	idle  time.Duration
+
+	lock      sync.Mutex
+	limiters  map[string]*keyedLimiter
+	lastSweep time.Time
+}
+
+type keyedLimiter struct {
+	l    Limiter
+	last time.Time
+}
+
+// NewKeyedLimiter creates a KeyedLimiter, newFn creates the limiter
+// of a new key. idle <= 0 means never evict.
+func NewKeyedLimiter(newFn func(key string) Limiter,
+	idle time.Duration) *KeyedLimiter {
+
+	return &KeyedLimiter{
+		newFn:     newFn,
+		idle:      idle,
+		limiters:  make(map[string]*keyedLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the limiter of the key, creating it if necessary.
+func (k *KeyedLimiter) Get(key string) Limiter {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	now := time.Now()
+	k.sweep(now)
+
+	e, ok := k.limiters[key]
+	if !ok {
+		e = &keyedLimiter{l: k.newFn(key)}
+		k.limiters[key] = e
+	}
+	e.last = now
+	return e.l
+}
+
+// Must be called with k.lock held.
+func (k *KeyedLimiter) sweep(now time.Time) {
+	if k.idle <= 0 || now.Sub(k.lastSweep) < k.idle {
+		return
+	}
+	k.lastSweep = now
+	for key, e := range k.limiters {
+		if now.Sub(e.last) > k.idle {
+			delete(k.limiters, key)
+		}
+	}
+}
+
+// Len returns the number of the keys.
+func (k *KeyedLimiter) Len() int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return len(k.limiters)
+}
+
+func (k *KeyedLimiter) Allow(key string) bool {
+	return k.Get(key).Allow()
+}
+
+func (k *KeyedLimiter) Reserve(key string) *Reservation {
+	return k.Get(key).Reserve()
+}
+
+func (k *KeyedLimiter) Wait(ctx context.Context, key string) error {
+	return k.Get(key).Wait(ctx)
+}
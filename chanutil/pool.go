@@ -0,0 +1,192 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrPoolFull   = errors.New("pool queue is full")
+	ErrPoolClosed = errors.New("pool is closed")
+)
+
+// SubmitPolicy defines what to do when the pool queue is full.
+type SubmitPolicy int
+
+const (
+	// Block the submitter until the queue has room.
+	SubmitBlock SubmitPolicy = iota
+	// Reject the task with ErrPoolFull.
+	SubmitReject
+)
+
+// PoolStats contains the counters of a Pool.
+type PoolStats struct {
+	Queued    int64 // tasks waiting in the queue
+	Running   int64 // tasks being run
+	Completed int64 // tasks returned normally
+	Panicked  int64 // tasks panicked
+}
+
+// Pool runs the submitted tasks on a fixed number of workers,
+// the tasks waiting for a worker are kept in a bounded queue.
+//
+// A panic in a task is recovered and reported through OnPanic,
+// the worker keeps running.
+//
+// Multiple goroutines can invoke methods on a Pool simultaneously.
+type Pool struct {
+	// OnPanic is called in the worker after a task panicked, e is the
+	// recovered value. If it is nil, the panic is logged through the
+	// standard logger. It must be set before any task is submitted.
+	OnPanic func(e interface{}, stack []byte)
+
+	policy SubmitPolicy
+	queue  chan func()
+
+	lock   sync.RWMutex // protects closed and queue closing
+	closed bool
+	quit   DoneChan
+	done   DoneChan
+	wg     sync.WaitGroup
+
+	queued    int64
+	running   int64
+	completed int64
+	panicked  int64
+}
+
+func NewPool(workers, queueSize int, policy SubmitPolicy) *Pool {
+	if workers <= 0 || queueSize < 0 {
+		panic(ErrInvalidArgs)
+	}
+
+	p := &Pool{
+		policy: policy,
+		queue:  make(chan func(), queueSize),
+		quit:   NewDoneChan(),
+		done:   NewDoneChan(),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go func() {
+		p.wg.Wait()
+		p.done.SetDone()
+	}()
+	return p
+}
+
+// Submit submits fn to the pool according to the policy.
+func (p *Pool) Submit(fn func()) error {
+	return p.SubmitCtx(context.Background(), fn)
+}
+
+// SubmitCtx submits fn to the pool according to the policy,
+// with SubmitBlock, it returns ctx.Err() if ctx is done before
+// the queue has room.
+func (p *Pool) SubmitCtx(ctx context.Context, fn func()) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	atomic.AddInt64(&p.queued, 1)
+	select {
+	case p.queue <- fn:
+		return nil
+	default:
+	}
+
+	if p.policy == SubmitReject {
+		atomic.AddInt64(&p.queued, -1)
+		return ErrPoolFull
+	}
+
+	select {
+	case p.queue <- fn:
+		return nil
+	case <-p.quit:
+		atomic.AddInt64(&p.queued, -1)
+		return ErrPoolClosed
+	case <-ctx.Done():
+		atomic.AddInt64(&p.queued, -1)
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for fn := range p.queue {
+		atomic.AddInt64(&p.queued, -1)
+		p.run(fn)
+	}
+}
+
+func (p *Pool) run(fn func()) {
+	atomic.AddInt64(&p.running, 1)
+	defer atomic.AddInt64(&p.running, -1)
+
+	defer func() {
+		if e := recover(); e != nil {
+			atomic.AddInt64(&p.panicked, 1)
+			stack := debug.Stack()
+			if p.OnPanic != nil {
+				p.OnPanic(e, stack)
+			} else {
+				log.Printf("chanutil: pool task panic: %v\n%s", e, stack)
+			}
+		}
+	}()
+
+	fn()
+	atomic.AddInt64(&p.completed, 1)
+}
+
+// Shutdown stops accepting new tasks, then waits until the queued tasks
+// are all done or ctx is done. In the latter case, the workers keep
+// draining the queue in the background and ctx.Err() is returned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	// Abort the blocked submitters first.
+	p.quit.SetDone()
+
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.lock.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is fired after the pool is shut down and all the tasks are done.
+func (p *Pool) Done() DoneChanR {
+	return p.done.R()
+}
+
+// Stats returns the counters of the pool.
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Queued:    atomic.LoadInt64(&p.queued),
+		Running:   atomic.LoadInt64(&p.running),
+		Completed: atomic.LoadInt64(&p.completed),
+		Panicked:  atomic.LoadInt64(&p.panicked),
+	}
+}
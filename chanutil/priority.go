@@ -0,0 +1,177 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"container/heap"
+	"errors"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+var ErrQueueClosed = errors.New("queue is closed")
+
+// PriorityQueue is a blocking queue where the values with higher
+// priority are received first, the values with the same priority
+// are received in FIFO order.
+//
+// With aging, a waiting value gains one priority level per aging
+// duration, so the low priority values are not starved forever.
+//
+// Multiple goroutines can invoke methods on a PriorityQueue simultaneously.
+type PriorityQueue[T any] struct {
+	lock   sync.Mutex
+	items  pqItems[T]
+	seq    uint64
+	start  time.Time
+	aging  time.Duration
+	closed bool
+
+	notify Event
+	out    chan T
+}
+
+type pqItem[T any] struct {
+	v     T
+	key   int64 // effective priority at the start time
+	seq   uint64
+	index int
+}
+
+// NewPriorityQueue creates a PriorityQueue, aging <= 0 means no aging.
+func NewPriorityQueue[T any](aging time.Duration) *PriorityQueue[T] {
+	q := &PriorityQueue[T]{
+		start:  time.Now(),
+		aging:  aging,
+		notify: NewEvent(),
+		out:    make(chan T),
+	}
+	go q.pump()
+	return q
+}
+
+// Push pushes v with the priority prio, the bigger the higher.
+func (q *PriorityQueue[T]) Push(v T, prio int) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	key := int64(prio)
+	if q.aging > 0 {
+		// Every value gains priority at the same rate, so the order of
+		// prio + (now-enqueued)/aging equals the order of the key below.
+		key = int64(prio)*int64(q.aging) - int64(time.Since(q.start))
+	}
+	q.seq++
+	heap.Push(&q.items, &pqItem[T]{v: v, key: key, seq: q.seq})
+	q.notify.Set()
+	return nil
+}
+
+// Pop receives the value with the highest priority, blocking until
+// there is one or ctx is done. It returns ErrQueueClosed after the
+// queue is closed and drained.
+func (q *PriorityQueue[T]) Pop(ctx context.Context) (T, error) {
+	select {
+	case v, ok := <-q.out:
+		if !ok {
+			var zero T
+			return zero, ErrQueueClosed
+		}
+		return v, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Out returns the chan to receive the values, it is closed after the
+// queue is closed and drained.
+func (q *PriorityQueue[T]) Out() <-chan T {
+	return q.out
+}
+
+// Len returns the number of values in the queue.
+func (q *PriorityQueue[T]) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.items.Len()
+}
+
+// Close stops accepting new values, the remaining values
+// are still delivered.
+func (q *PriorityQueue[T]) Close() error {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	q.notify.Set()
+	return nil
+}
+
+func (q *PriorityQueue[T]) pump() {
+	defer close(q.out)
+
+	for {
+		q.lock.Lock()
+		if q.items.Len() == 0 {
+			closed := q.closed
+			q.lock.Unlock()
+			if closed {
+				return
+			}
+			<-q.notify
+			continue
+		}
+		top := q.items[0]
+		q.lock.Unlock()
+
+		select {
+		case q.out <- top.v:
+			q.lock.Lock()
+			// A higher one may be pushed before top is sent.
+			heap.Remove(&q.items, top.index)
+			q.lock.Unlock()
+		case <-q.notify:
+		}
+	}
+}
+
+// pqItems implements heap.Interface.
+type pqItems[T any] []*pqItem[T]
+
+func (h pqItems[T]) Len() int {
+	return len(h)
+}
+
+func (h pqItems[T]) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key > h[j].key
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h pqItems[T]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *pqItems[T]) Push(x interface{}) {
+	it := x.(*pqItem[T])
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *pqItems[T]) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return it
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The upper bounds of the wait time histogram buckets, the last
+// bucket (not listed) holds the longer ones.
+var SemaphoreWaitBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// SemaphoreHolder describes an acquisition which is not released yet.
+type SemaphoreHolder struct {
+	N         int       // number of resources held
+	Goroutine int64     // id of the acquiring goroutine
+	Since     time.Time // acquisition time
+	Stack     string    // acquisition stack
+}
+
+// SemaphoreDebugInfo is the state of a Semaphore in the debug mode.
+type SemaphoreDebugInfo struct {
+	Limit   int
+	InUse   int
+	Waiters int
+
+	// Untracked is the number of resources held without records, they
+	// were acquired before the debug mode was turned on.
+	Untracked int
+
+	// Holders in acquisition order.
+	Holders []SemaphoreHolder
+	// Holders which have held longer than the threshold.
+	LongHeld []SemaphoreHolder
+	// WaitHist[i] is the number of acquisitions which waited no longer
+	// than SemaphoreWaitBuckets[i], the last one counts the others.
+	WaitHist []int64
+}
+
+type semaDebug struct {
+	longHeld time.Duration
+	holders  list.List // of *SemaphoreHolder
+	waitHist []int64
+
+	// The held resources without records, untracked plus the N of
+	// holders is always s.cur.
+	untracked int
+}
+
+type semaTrace struct {
+	gid   int64
+	stack []byte
+}
+
+// EnableDebug turns on the debug mode, which records the stack and time
+// of each acquisition, and the wait time histogram. The holders which
+// have held longer than longHeld are reported as long held.
+//
+// The debug mode is slow, it is intended for diagnosing stalls. Only the
+// acquisitions after it is turned on are tracked.
+func (s *WeightedSemaphore) EnableDebug(longHeld time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.debug == nil {
+		s.debug = &semaDebug{
+			waitHist:  make([]int64, len(SemaphoreWaitBuckets)+1),
+			untracked: s.cur,
+		}
+	}
+	s.debug.longHeld = longHeld
+	atomic.StoreInt32(&s.debugOn, 1)
+}
+
+// DisableDebug turns off the debug mode and discards the records.
+func (s *WeightedSemaphore) DisableDebug() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.debug = nil
+	atomic.StoreInt32(&s.debugOn, 0)
+}
+
+// DebugInfo returns the state of the semaphore, the debug fields
+// are empty if the debug mode is off.
+func (s *WeightedSemaphore) DebugInfo() SemaphoreDebugInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	info := SemaphoreDebugInfo{
+		Limit:   s.size,
+		InUse:   s.cur,
+		Waiters: s.waiters.Len(),
+	}
+	d := s.debug
+	if d == nil {
+		return info
+	}
+	info.Untracked = d.untracked
+
+	now := time.Now()
+	for e := d.holders.Front(); e != nil; e = e.Next() {
+		h := *e.Value.(*SemaphoreHolder)
+		info.Holders = append(info.Holders, h)
+		if d.longHeld > 0 && now.Sub(h.Since) > d.longHeld {
+			info.LongHeld = append(info.LongHeld, h)
+		}
+	}
+	info.WaitHist = append([]int64(nil), d.waitHist...)
+	return info
+}
+
+// DumpDebug writes the debug info in text, it can be called from
+// an admin endpoint.
+func (s *WeightedSemaphore) DumpDebug(w io.Writer) error {
+	info := s.DebugInfo()
+	now := time.Now()
+
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "limit: %d, in use: %d, waiters: %d\n",
+		info.Limit, info.InUse, info.Waiters)
+	if info.WaitHist == nil {
+		fmt.Fprintln(b, "debug mode is off")
+		_, err := w.Write(b.Bytes())
+		return err
+	}
+
+	fmt.Fprintf(b, "untracked: %d\n", info.Untracked)
+	fmt.Fprintln(b)
+	fmt.Fprintln(b, "wait time:")
+	for i, c := range info.WaitHist {
+		if i < len(SemaphoreWaitBuckets) {
+			fmt.Fprintf(b, "    <= %-8v %d\n", SemaphoreWaitBuckets[i], c)
+		} else {
+			fmt.Fprintf(b, "    >  %-8v %d\n", SemaphoreWaitBuckets[i-1], c)
+		}
+	}
+
+	dumpHolders := func(title string, hs []SemaphoreHolder) {
+		fmt.Fprintln(b)
+		fmt.Fprintf(b, "%s: %d\n", title, len(hs))
+		for _, h := range hs {
+			fmt.Fprintln(b)
+			fmt.Fprintf(b, "goroutine %d holds %d for %v\n",
+				h.Goroutine, h.N, now.Sub(h.Since))
+			fmt.Fprintln(b, h.Stack)
+		}
+	}
+	dumpHolders("long held", info.LongHeld)
+	dumpHolders("holders", info.Holders)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// traceCaller returns the trace of the caller if the debug mode is on.
+func (s *WeightedSemaphore) traceCaller(withStack bool) *semaTrace {
+	if atomic.LoadInt32(&s.debugOn) == 0 {
+		return nil
+	}
+
+	size := 64
+	if withStack {
+		size = 4096
+	}
+	buf := make([]byte, size)
+	buf = buf[:runtime.Stack(buf, false)]
+
+	t := &semaTrace{gid: parseGoroutineID(buf)}
+	if withStack {
+		t.stack = buf
+	}
+	return t
+}
+
+// debugHold records the acquisition of n resources, the acquisition
+// without trace (the debug mode was turned on after it started) is
+// counted as untracked.
+// Must be called with s.lock held.
+func (s *WeightedSemaphore) debugHold(n int, t *semaTrace, wait time.Duration) {
+	d := s.debug
+	if d == nil {
+		return
+	}
+	if t == nil {
+		d.untracked += n
+		return
+	}
+
+	i := 0
+	for i < len(SemaphoreWaitBuckets) && wait > SemaphoreWaitBuckets[i] {
+		i++
+	}
+	d.waitHist[i]++
+
+	d.holders.PushBack(&SemaphoreHolder{
+		N:         n,
+		Goroutine: t.gid,
+		Since:     time.Now(),
+		Stack:     string(t.stack),
+	})
+}
+
+// debugRelease removes the records of n resources. It removes the ones
+// held by the same goroutine first, then counts down the untracked ones.
+// Only if all the held resources are tracked, the rest must have been
+// acquired by other goroutines, and the oldest records are removed.
+// Must be called with s.lock held.
+func (s *WeightedSemaphore) debugRelease(n int, t *semaTrace) {
+	d := s.debug
+	if d == nil {
+		return
+	}
+
+	var gid int64 = -1
+	if t != nil {
+		gid = t.gid
+	}
+	for n > 0 {
+		e := d.holders.Back()
+		for ; e != nil; e = e.Prev() {
+			if e.Value.(*SemaphoreHolder).Goroutine == gid {
+				break
+			}
+		}
+		if e == nil && d.untracked > 0 {
+			m := min(n, d.untracked)
+			d.untracked -= m
+			n -= m
+			continue
+		}
+		if e == nil {
+			e = d.holders.Front()
+		}
+		if e == nil {
+			return
+		}
+
+		h := e.Value.(*SemaphoreHolder)
+		if h.N > n {
+			h.N -= n
+			return
+		}
+		n -= h.N
+		d.holders.Remove(e)
+	}
+}
+
+// parseGoroutineID parses the id from "goroutine 123 [running]:...".
+func parseGoroutineID(stack []byte) int64 {
+	stack = bytes.TrimPrefix(stack, []byte("goroutine "))
+	if i := bytes.IndexByte(stack, ' '); i > 0 {
+		stack = stack[:i]
+	}
+	id, err := strconv.ParseInt(string(stack), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return id
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"bytes"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeightedSemaphoreDebugHolders(t *testing.T) {
+	s := NewWeightedSemaphore(3)
+	s.EnableDebug(time.Millisecond)
+	s.Acquire(2)
+
+	info := s.DebugInfo()
+	if len(info.Holders) != 1 {
+		t.Fatalf("holders %d, want 1", len(info.Holders))
+	}
+	h := info.Holders[0]
+	if h.N != 2 || h.Goroutine <= 0 || !strings.Contains(h.Stack, "TestWeightedSemaphoreDebugHolders") {
+		t.Fatalf("bad holder %+v", h)
+	}
+	if info.WaitHist[0] != 1 {
+		t.Fatalf("wait hist %v", info.WaitHist)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if info := s.DebugInfo(); len(info.LongHeld) != 1 {
+		t.Fatalf("long held %d, want 1", len(info.LongHeld))
+	}
+	var b bytes.Buffer
+	if err := s.DumpDebug(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "holders: 1") {
+		t.Fatalf("bad dump:\n%s", b.String())
+	}
+
+	// A partial release keeps the record.
+	s.Release(1)
+	if info := s.DebugInfo(); len(info.Holders) != 1 || info.Holders[0].N != 1 {
+		t.Fatalf("holders %+v", info.Holders)
+	}
+	s.Release(1)
+	if info := s.DebugInfo(); len(info.Holders) != 0 {
+		t.Fatalf("holders %+v", info.Holders)
+	}
+
+	s.DisableDebug()
+	s.Acquire(1)
+	if info := s.DebugInfo(); info.Holders != nil || info.WaitHist != nil {
+		t.Fatal("debug info after DisableDebug")
+	}
+}
+
+func TestWeightedSemaphoreDebugUntracked(t *testing.T) {
+	s := NewWeightedSemaphore(3)
+	s.Acquire(1)
+	s.EnableDebug(time.Hour)
+	if info := s.DebugInfo(); info.Untracked != 1 {
+		t.Fatalf("untracked %d, want 1", info.Untracked)
+	}
+
+	held := make(chan struct{})
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Acquire(1)
+		close(held)
+		<-quit
+		s.Release(1)
+		close(done)
+	}()
+	<-held
+
+	// Releasing the resource acquired before EnableDebug must not
+	// erase the record of the goroutine which still holds one.
+	s.Release(1)
+	info := s.DebugInfo()
+	if info.InUse != 1 || len(info.Holders) != 1 || info.Untracked != 0 {
+		t.Fatalf("in use %d, holders %d, untracked %d",
+			info.InUse, len(info.Holders), info.Untracked)
+	}
+
+	close(quit)
+	<-done
+	if info := s.DebugInfo(); info.InUse != 0 || len(info.Holders) != 0 {
+		t.Fatalf("in use %d, holders %d", info.InUse, len(info.Holders))
+	}
+}
+
+func TestWeightedSemaphoreDebugCrossGoroutine(t *testing.T) {
+	s := NewWeightedSemaphore(3)
+	s.EnableDebug(time.Hour)
+	s.Acquire(2)
+
+	done := make(chan struct{})
+	go func() {
+		s.Release(2)
+		close(done)
+	}()
+	<-done
+	if info := s.DebugInfo(); len(info.Holders) != 0 || info.Untracked != 0 {
+		t.Fatalf("holders %d, untracked %d", len(info.Holders), info.Untracked)
+	}
+}
+
+func TestWeightedSemaphoreDebugWait(t *testing.T) {
+	s := NewWeightedSemaphore(1)
+	s.EnableDebug(time.Hour)
+	s.Acquire(1)
+	c := acquireAsync(context.Background(), s, 1)
+	waitWaiters(t, s, 1)
+	time.Sleep(2 * time.Millisecond)
+	s.Release(1)
+	if err := <-c; err != nil {
+		t.Fatal(err)
+	}
+
+	info := s.DebugInfo()
+	if len(info.Holders) != 1 {
+		t.Fatalf("holders %d, want 1", len(info.Holders))
+	}
+	// The first one did not wait, the second one waited >= 2ms.
+	var total int64
+	for _, n := range info.WaitHist {
+		total += n
+	}
+	if info.WaitHist[0] != 1 || total != 2 {
+		t.Fatalf("wait hist %v", info.WaitHist)
+	}
+}
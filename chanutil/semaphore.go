@@ -0,0 +1,221 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"container/list"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+// WeightedSemaphore can be used to limit access to multiple resources.
+//
+// An acquisition of n resources is all-or-nothing,
+// and the waiters are served in FIFO order, so a big request will not be
+// starved by the small ones behind it.
+//
+// The limit can be changed at runtime through SetLimit.
+//
+// The debug mode (see EnableDebug) tracks the holders and the wait time.
+//
+// Multiple goroutines can invoke methods on a WeightedSemaphore simultaneously.
+type WeightedSemaphore struct {
+	lock    sync.Mutex
+	size    int
+	cur     int
+	waiters list.List
+
+	debugOn int32      // atomic, for the checks outside the lock
+	debug   *semaDebug // nil if the debug mode is off
+}
+
+type semaWaiter struct {
+	n     int
+	ready chan struct{} // closed when the resources are acquired
+
+	// for the debug mode
+	trace *semaTrace
+	start time.Time
+}
+
+func NewWeightedSemaphore(n int) *WeightedSemaphore {
+	if n <= 0 {
+		panic(ErrInvalidArgs)
+	}
+	return &WeightedSemaphore{size: n}
+}
+
+// Acquire n resources, blocking until they are available.
+func (s *WeightedSemaphore) Acquire(n int) {
+	s.AcquireCtx(context.Background(), n)
+}
+
+// AcquireCtx acquires n resources, blocking until they are available
+// or ctx is done. On success, returns nil. On failure, returns ctx.Err()
+// and leaves the semaphore unchanged.
+//
+// If n is more than the limit, it waits until the limit is raised,
+// without blocking the other acquisitions, see SetLimit.
+func (s *WeightedSemaphore) AcquireCtx(ctx context.Context, n int) error {
+	if n < 0 {
+		panic(ErrInvalidArgs)
+	}
+	trace := s.traceCaller(true)
+
+	s.lock.Lock()
+	if s.size-s.cur >= n && !s.hasEligibleWaiter() {
+		s.cur += n
+		s.debugHold(n, trace, 0)
+		s.lock.Unlock()
+		return nil
+	}
+
+	w := &semaWaiter{n: n, ready: make(chan struct{}), trace: trace}
+	if trace != nil {
+		w.start = time.Now()
+	}
+	elem := s.waiters.PushBack(w)
+	// The waiters ahead may be all oversized.
+	s.notifyWaiters()
+	s.lock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		err := ctx.Err()
+		s.lock.Lock()
+		select {
+		case <-w.ready:
+			// Acquired after ctx done, put the resources back.
+			s.cur -= n
+			s.debugRelease(n, trace)
+			s.notifyWaiters()
+		default:
+			s.waiters.Remove(elem)
+			// The waiters behind may be satisfied now.
+			s.notifyWaiters()
+		}
+		s.lock.Unlock()
+		return err
+
+	case <-w.ready:
+		return nil
+	}
+}
+
+// TryAcquire acquires n resources without blocking.
+// On success, returns true. On failure (including n is more than
+// the limit), returns false and leaves the semaphore unchanged.
+func (s *WeightedSemaphore) TryAcquire(n int) bool {
+	if n < 0 {
+		panic(ErrInvalidArgs)
+	}
+	trace := s.traceCaller(true)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.size-s.cur >= n && !s.hasEligibleWaiter() {
+		s.cur += n
+		s.debugHold(n, trace, 0)
+		return true
+	}
+	return false
+}
+
+// Release n resources.
+func (s *WeightedSemaphore) Release(n int) {
+	if n < 0 {
+		panic(ErrInvalidArgs)
+	}
+	trace := s.traceCaller(false)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n > s.cur {
+		panic(ErrInvalidArgs)
+	}
+	s.cur -= n
+	s.debugRelease(n, trace)
+	s.notifyWaiters()
+}
+
+// SetLimit changes the limit of the semaphore. If the limit is raised,
+// the waiters are woken as far as possible. If the limit is lowered,
+// the acquired resources are not revoked, it takes effect as the
+// holders release.
+//
+// The waiters which require more than the new limit stay waiting until
+// the limit is raised again, or their ctx is done. They are skipped in
+// the FIFO order, so they do not block the waiters behind them.
+func (s *WeightedSemaphore) SetLimit(n int) {
+	if n <= 0 {
+		panic(ErrInvalidArgs)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.size = n
+	s.notifyWaiters()
+}
+
+// Limit returns the current limit.
+func (s *WeightedSemaphore) Limit() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+// InUse returns the number of acquired resources, it may exceed
+// the limit for a while after the limit is lowered.
+func (s *WeightedSemaphore) InUse() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cur
+}
+
+// Waiters returns the number of waiting acquisitions.
+func (s *WeightedSemaphore) Waiters() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.waiters.Len()
+}
+
+// hasEligibleWaiter reports whether there is a waiter which requires
+// no more than the limit. Must be called with s.lock held.
+func (s *WeightedSemaphore) hasEligibleWaiter() bool {
+	for e := s.waiters.Front(); e != nil; e = e.Next() {
+		if e.Value.(*semaWaiter).n <= s.size {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyWaiters wakes the waiters in FIFO order, it skips the waiters
+// which require more than the limit, and stops at the first other waiter
+// which can not be satisfied. Must be called with s.lock held.
+func (s *WeightedSemaphore) notifyWaiters() {
+	for e := s.waiters.Front(); e != nil; {
+		w := e.Value.(*semaWaiter)
+		if w.n > s.size {
+			e = e.Next()
+			continue
+		}
+		if s.size-s.cur < w.n {
+			break
+		}
+
+		s.cur += w.n
+		var wait time.Duration
+		if w.trace != nil {
+			wait = time.Since(w.start)
+		}
+		s.debugHold(w.n, w.trace, wait)
+		next := e.Next()
+		s.waiters.Remove(e)
+		close(w.ready)
+		e = next
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+// acquireAsync acquires n resources in a new goroutine, the returned
+// chan receives the result.
+func acquireAsync(ctx context.Context, s *WeightedSemaphore, n int) <-chan error {
+	c := make(chan error, 1)
+	go func() { c <- s.AcquireCtx(ctx, n) }()
+	return c
+}
+
+// waitWaiters waits until s has n waiters.
+func waitWaiters(t *testing.T, s *WeightedSemaphore, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Waiters() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("waiters: got %d, want %d", s.Waiters(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	s := NewSemaphore(2)
+	s.Acquire(2)
+	if len(s) != 2 {
+		t.Fatalf("len %d, want 2", len(s))
+	}
+	s.Release(1)
+	s <- struct{}{}
+	s.Release(2)
+	if len(s) != 0 {
+		t.Fatalf("len %d, want 0", len(s))
+	}
+}
+
+func TestWeightedSemaphoreFIFO(t *testing.T) {
+	s := NewWeightedSemaphore(3)
+	s.Acquire(2)
+
+	big := acquireAsync(context.Background(), s, 3)
+	waitWaiters(t, s, 1)
+	small := acquireAsync(context.Background(), s, 1)
+	waitWaiters(t, s, 2)
+
+	// One resource is free, but the big waiter is ahead.
+	if s.TryAcquire(1) {
+		t.Fatal("TryAcquire jumped the queue")
+	}
+	if s.InUse() != 2 {
+		t.Fatalf("in use %d, want 2", s.InUse())
+	}
+
+	s.Release(2)
+	if err := <-big; err != nil {
+		t.Fatal(err)
+	}
+	if s.InUse() != 3 || s.Waiters() != 1 {
+		t.Fatalf("in use %d, waiters %d", s.InUse(), s.Waiters())
+	}
+
+	s.Release(3)
+	if err := <-small; err != nil {
+		t.Fatal(err)
+	}
+	if s.InUse() != 1 || s.Waiters() != 0 {
+		t.Fatalf("in use %d, waiters %d", s.InUse(), s.Waiters())
+	}
+}
+
+func TestWeightedSemaphoreCancel(t *testing.T) {
+	s := NewWeightedSemaphore(2)
+	s.Acquire(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	big := acquireAsync(ctx, s, 2)
+	waitWaiters(t, s, 1)
+	small := acquireAsync(context.Background(), s, 1)
+	waitWaiters(t, s, 2)
+
+	// Cancelling the front waiter lets the ones behind go.
+	cancel()
+	if err := <-big; err != context.Canceled {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+	if err := <-small; err != nil {
+		t.Fatal(err)
+	}
+	if s.InUse() != 2 || s.Waiters() != 0 {
+		t.Fatalf("in use %d, waiters %d", s.InUse(), s.Waiters())
+	}
+
+	// A failed acquisition leaves the semaphore unchanged.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.AcquireCtx(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	if s.InUse() != 2 || s.Waiters() != 0 {
+		t.Fatalf("in use %d, waiters %d", s.InUse(), s.Waiters())
+	}
+}
+
+func TestWeightedSemaphoreSetLimit(t *testing.T) {
+	s := NewWeightedSemaphore(1)
+	s.Acquire(1)
+	c1 := acquireAsync(context.Background(), s, 1)
+	waitWaiters(t, s, 1)
+	c2 := acquireAsync(context.Background(), s, 1)
+	waitWaiters(t, s, 2)
+
+	// Raising the limit wakes the waiters.
+	s.SetLimit(3)
+	if err := <-c1; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-c2; err != nil {
+		t.Fatal(err)
+	}
+
+	// Lowering the limit does not revoke, it takes effect as the
+	// holders release.
+	s.SetLimit(1)
+	if s.Limit() != 1 || s.InUse() != 3 {
+		t.Fatalf("limit %d, in use %d", s.Limit(), s.InUse())
+	}
+	s.Release(2)
+	if s.TryAcquire(1) {
+		t.Fatal("TryAcquire over the lowered limit succeeded")
+	}
+	s.Release(1)
+	if !s.TryAcquire(1) {
+		t.Fatal("TryAcquire under the limit failed")
+	}
+}
+
+func TestWeightedSemaphoreReleaseTooMany(t *testing.T) {
+	s := NewWeightedSemaphore(2)
+	s.Acquire(1)
+	defer func() {
+		if recover() != ErrInvalidArgs {
+			t.Fatal("no panic")
+		}
+	}()
+	s.Release(2)
+}
+
+func TestWeightedSemaphoreOversized(t *testing.T) {
+	s := NewWeightedSemaphore(4)
+	s.SetLimit(2)
+
+	if s.TryAcquire(3) {
+		t.Fatal("TryAcquire over the limit succeeded")
+	}
+	big := acquireAsync(context.Background(), s, 3)
+	waitWaiters(t, s, 1)
+
+	// The oversized waiter does not block the others.
+	if !s.TryAcquire(1) {
+		t.Fatal("TryAcquire blocked by an oversized waiter")
+	}
+	if err := s.AcquireCtx(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	small := acquireAsync(context.Background(), s, 1)
+	waitWaiters(t, s, 2)
+	s.Release(1)
+	if err := <-small; err != nil {
+		t.Fatal(err)
+	}
+
+	// Raising the limit wakes it.
+	s.Release(2)
+	s.SetLimit(3)
+	if err := <-big; err != nil {
+		t.Fatal(err)
+	}
+	if s.InUse() != 3 || s.Waiters() != 0 {
+		t.Fatalf("in use %d, waiters %d", s.InUse(), s.Waiters())
+	}
+}
+
+func TestWeightedSemaphoreOversizedCancel(t *testing.T) {
+	s := NewWeightedSemaphore(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.AcquireCtx(ctx, 3); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	if s.Waiters() != 0 || s.InUse() != 0 {
+		t.Fatalf("in use %d, waiters %d", s.InUse(), s.Waiters())
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"iter"
+	"runtime"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// ChanIndex is the common interface of Chanmap and ShardedChanmap.
+type ChanIndex[T any] interface {
+	SetTTL(ttl time.Duration)
+	New() (id ChanID, c chan T)
+	NewF(size int) (id ChanID, c chan T)
+	NewFT(size int, ttl time.Duration) (id ChanID, c chan T)
+	Get(id ChanID) chan T
+	Remove(id ChanID)
+	Len() int
+	Stats() ChanmapStats
+	Range(fn func(id ChanID, c chan T) bool)
+	Snapshot() iter.Seq2[ChanID, chan T]
+
+	newExpiring(size int) (id ChanID, c chan T, expired DoneChanR)
+	deliver(id ChanID, v T) bool
+}
+
+// ShardedChanmap is a Chanmap split into several shards, each shard has
+// its own lock, and the ids are allocated without lock. It is intended
+// for the workloads where many goroutines create and remove chans at
+// the same time.
+//
+// Multiple goroutines can invoke methods on a ShardedChanmap simultaneously.
+type ShardedChanmap[T any] struct {
+	// OnExpire is called (in a separate goroutine) after a chan expired
+	// and has been removed. It must be set before any chan is created.
+	OnExpire func(id ChanID, c chan T)
+
+	shards      []*ChanmapOf[T]
+	mask        int64
+	lastID      int64
+	defChanSize int
+	ttl         int64 // time.Duration
+}
+
+// NewShardedChanmap creates an untyped ShardedChanmap.
+// If shards <= 0, a default number based on GOMAXPROCS is used.
+func NewShardedChanmap(shards, defChanSize int) *ShardedChanmap[interface{}] {
+	return NewShardedChanmapOf[interface{}](shards, defChanSize)
+}
+
+// NewShardedChanmapOf creates a ShardedChanmap, the number of shards is
+// rounded up to a power of 2. If shards <= 0, a default number based on
+// GOMAXPROCS is used.
+func NewShardedChanmapOf[T any](shards, defChanSize int) *ShardedChanmap[T] {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0) * 4
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	m := &ShardedChanmap[T]{
+		shards:      make([]*ChanmapOf[T], n),
+		mask:        int64(n - 1),
+		defChanSize: defChanSize,
+	}
+	onExpire := func(id ChanID, c chan T) {
+		if m.OnExpire != nil {
+			m.OnExpire(id, c)
+		}
+	}
+	for i := range m.shards {
+		s := NewChanmapOf[T](defChanSize)
+		s.OnExpire = onExpire
+		m.shards[i] = s
+	}
+	return m
+}
+
+func (m *ShardedChanmap[T]) shard(id ChanID) *ChanmapOf[T] {
+	return m.shards[int64(id)&m.mask]
+}
+
+// SetTTL sets the default TTL of the chans created after it,
+// ttl <= 0 means no TTL.
+func (m *ShardedChanmap[T]) SetTTL(ttl time.Duration) {
+	atomic.StoreInt64(&m.ttl, int64(ttl))
+}
+
+func (m *ShardedChanmap[T]) New() (id ChanID, c chan T) {
+	return m.NewF(m.defChanSize)
+}
+
+func (m *ShardedChanmap[T]) NewF(size int) (id ChanID, c chan T) {
+	return m.NewFT(size, time.Duration(atomic.LoadInt64(&m.ttl)))
+}
+
+// NewFT creates a chan with the specified size and TTL,
+// ttl <= 0 means no TTL.
+func (m *ShardedChanmap[T]) NewFT(size int, ttl time.Duration) (id ChanID, c chan T) {
+	id = ChanID(atomic.AddInt64(&m.lastID, 1))
+	s := m.shard(id)
+	s.chanLock.Lock()
+	defer s.chanLock.Unlock()
+	c = s.addLocked(id, size, ttl).c
+	return
+}
+
+func (m *ShardedChanmap[T]) newExpiring(size int) (id ChanID, c chan T, expired DoneChanR) {
+	id = ChanID(atomic.AddInt64(&m.lastID, 1))
+	s := m.shard(id)
+	s.chanLock.Lock()
+	defer s.chanLock.Unlock()
+	e := s.addLocked(id, size, time.Duration(atomic.LoadInt64(&m.ttl)))
+	return id, e.c, e.expired.R()
+}
+
+func (m *ShardedChanmap[T]) Get(id ChanID) chan T {
+	return m.shard(id).Get(id)
+}
+
+func (m *ShardedChanmap[T]) Remove(id ChanID) {
+	m.shard(id).Remove(id)
+}
+
+// Len returns the number of chans in the map.
+func (m *ShardedChanmap[T]) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		n += s.Len()
+	}
+	return n
+}
+
+// Stats returns the statistics of the map, it is not an atomic
+// snapshot across the shards.
+func (m *ShardedChanmap[T]) Stats() ChanmapStats {
+	var st ChanmapStats
+	for _, s := range m.shards {
+		ss := s.Stats()
+		st.Live += ss.Live
+		st.Expired += ss.Expired
+		st.Removed += ss.Removed
+		if ss.OldestAge > st.OldestAge {
+			st.OldestAge = ss.OldestAge
+		}
+	}
+	return st
+}
+
+// Range calls fn for each chan in the map, in no particular order,
+// until fn returns false. Each shard is locked during its iteration,
+// so fn must not call the methods of m, use Snapshot in that case.
+func (m *ShardedChanmap[T]) Range(fn func(id ChanID, c chan T) bool) {
+	goon := true
+	for _, s := range m.shards {
+		s.Range(func(id ChanID, c chan T) bool {
+			goon = fn(id, c)
+			return goon
+		})
+		if !goon {
+			return
+		}
+	}
+}
+
+// Snapshot returns an iterator over a copy of the map, in ascending id
+// order. The map is not locked during the iteration.
+func (m *ShardedChanmap[T]) Snapshot() iter.Seq2[ChanID, chan T] {
+	var ids []ChanID
+	cs := make(map[ChanID]chan T)
+	for _, s := range m.shards {
+		for id, c := range s.Snapshot() {
+			ids = append(ids, id)
+			cs[id] = c
+		}
+	}
+	slices.Sort(ids)
+
+	return func(yield func(ChanID, chan T) bool) {
+		for _, id := range ids {
+			if !yield(id, cs[id]) {
+				return
+			}
+		}
+	}
+}
+
+func (m *ShardedChanmap[T]) deliver(id ChanID, v T) bool {
+	return m.shard(id).deliver(id, v)
+}
@@ -0,0 +1,33 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chanutil
+
+import (
+	"testing"
+)
+
+// benchmarkChanIndex creates, gets and removes chans from parallel
+// goroutines, which is the workload ShardedChanmap is intended for.
+func benchmarkChanIndex(b *testing.B, m ChanIndex[interface{}]) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id, _ := m.New()
+			if m.Get(id) == nil {
+				b.Error("chan not found")
+				return
+			}
+			m.Remove(id)
+		}
+	})
+}
+
+func BenchmarkChanmap(b *testing.B) {
+	benchmarkChanIndex(b, NewChanmap(1))
+}
+
+func BenchmarkShardedChanmap(b *testing.B) {
+	benchmarkChanIndex(b, NewShardedChanmap(0, 1))
+}
@@ -0,0 +1,225 @@
+// Copyright 2014 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdset
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArgType defines the type of a positional argument.
+type ArgType int
+
+const (
+	ArgString   ArgType = iota // any string
+	ArgInt                     // an integer, see strconv.Atoi
+	ArgDuration                // a duration, see time.ParseDuration
+	ArgPath                    // a non-empty path, it is cleaned by filepath.Clean
+	ArgEnum                    // one of the ArgSpec.Enum values
+)
+
+var argTypeNames = [...]string{"string", "int", "duration", "path", "enum"}
+
+func (t ArgType) String() string {
+	if t < 0 || int(t) >= len(argTypeNames) {
+		return "ArgType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return argTypeNames[t]
+}
+
+// An ArgSpec specifies a named positional argument of a cmd.
+type ArgSpec struct {
+	Name     string   // name as it appears in the usage line
+	Explain  string   // explain message
+	Type     ArgType  // the type of the value
+	Enum     []string // the valid values of ArgEnum
+	Optional bool     // whether the argument can be omitted
+	Variadic bool     // whether the argument takes the rest, only for the last one
+}
+
+// usage returns the argument in the usage line, such as "<src>" or "[files...]".
+func (s *ArgSpec) usage() string {
+	n := s.Name
+	if s.Variadic {
+		n += "..."
+	}
+	if s.Optional {
+		return "[" + n + "]"
+	}
+	return "<" + n + ">"
+}
+
+// check returns the value of v, or an error if v is invalid.
+func (s *ArgSpec) check(v string) (string, error) {
+	switch s.Type {
+	case ArgInt:
+		if _, err := strconv.Atoi(v); err != nil {
+			return "", fmt.Errorf("invalid value %q for argument %s: must be an int", v, s.usage())
+		}
+	case ArgDuration:
+		if _, err := time.ParseDuration(v); err != nil {
+			return "", fmt.Errorf("invalid value %q for argument %s: must be a duration", v, s.usage())
+		}
+	case ArgPath:
+		if v == "" {
+			return "", fmt.Errorf("invalid value %q for argument %s: must be a path", v, s.usage())
+		}
+		v = filepath.Clean(v)
+	case ArgEnum:
+		for _, e := range s.Enum {
+			if v == e {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("invalid value %q for argument %s: must be one of: %s",
+			v, s.usage(), strings.Join(s.Enum, ", "))
+	}
+	return v, nil
+}
+
+// SetArgs specifies the positional arguments of the cmd, they are validated
+// by parsing. The required arguments must come before the optional ones,
+// and only the last one can be variadic. It panics if specs are invalid.
+func (cmd *Cmd) SetArgs(specs ...ArgSpec) {
+	names := make(map[string]bool)
+	for i, s := range specs {
+		var msg string
+		switch {
+		case s.Name == "":
+			msg = "argument with no name"
+		case names[s.Name]:
+			msg = "argument redefined: " + s.Name
+		case s.Variadic && i != len(specs)-1:
+			msg = "variadic argument not last: " + s.Name
+		case !s.Optional && i > 0 && specs[i-1].Optional:
+			msg = "required argument after optional: " + s.Name
+		case s.Type == ArgEnum && len(s.Enum) == 0:
+			msg = "enum argument with no values: " + s.Name
+		}
+		if msg != "" {
+			panic(fmt.Sprintf("cmdset: %s %s", cmd.fullName(), msg))
+		}
+		names[s.Name] = true
+	}
+	cmd.argSpecs = specs
+}
+
+// UsageLine returns the usage line of the cmd, such as
+// "prog copy <src> <dst> [files...]".
+func (cmd *Cmd) UsageLine() string {
+	name := cmd.fullName()
+	if cmd.HasSubCmds() {
+		return name + " command [arguments]"
+	}
+	if cmd.argSpecs == nil {
+		return name + " [arguments]"
+	}
+	parts := []string{name}
+	for i := range cmd.argSpecs {
+		parts = append(parts, cmd.argSpecs[i].usage())
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseArgs validates the arguments of cmd against its specs.
+func (c *CmdSet) parseArgs(cmd *Cmd) error {
+	cmd.argValues = nil
+	if cmd.argSpecs == nil {
+		return nil
+	}
+
+	args := cmd.Args()
+	values := make(map[string][]string)
+	for _, s := range cmd.argSpecs {
+		if len(args) == 0 {
+			if !s.Optional {
+				return c.cmdFailf(cmd, "missing argument: %s", s.usage())
+			}
+			break
+		}
+		n := 1
+		if s.Variadic {
+			n = len(args)
+		}
+		for _, a := range args[:n] {
+			v, err := s.check(a)
+			if err != nil {
+				return c.cmdFailf(cmd, "%v", err)
+			}
+			values[s.Name] = append(values[s.Name], v)
+		}
+		args = args[n:]
+	}
+	if len(args) > 0 {
+		return c.cmdFailf(cmd, "too many arguments: %s", strings.Join(args, " "))
+	}
+	cmd.argValues = values
+	return nil
+}
+
+// NamedArg returns the value of the named argument, or the first value
+// if it is variadic. It returns "" if the argument is omitted.
+func (cmd *Cmd) NamedArg(name string) string {
+	if vs := cmd.argValues[name]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// NamedArgs returns the values of the named (variadic) argument.
+func (cmd *Cmd) NamedArgs(name string) []string {
+	return cmd.argValues[name]
+}
+
+// IntArg returns the value of the named ArgInt argument,
+// or 0 if it is omitted.
+func (cmd *Cmd) IntArg(name string) int {
+	i, _ := strconv.Atoi(cmd.NamedArg(name))
+	return i
+}
+
+// DurationArg returns the value of the named ArgDuration argument,
+// or 0 if it is omitted.
+func (cmd *Cmd) DurationArg(name string) time.Duration {
+	d, _ := time.ParseDuration(cmd.NamedArg(name))
+	return d
+}
+
+func (cmd *Cmd) printArgs() {
+	output := cmd.Output()
+	maxLen := 0
+	for i := range cmd.argSpecs {
+		if n := len(cmd.argSpecs[i].usage()); n > maxLen {
+			maxLen = n
+		}
+	}
+	for i := range cmd.argSpecs {
+		s := &cmd.argSpecs[i]
+		explain := s.Explain
+		switch s.Type {
+		case ArgString:
+		case ArgEnum:
+			explain += " (one of: " + strings.Join(s.Enum, ", ") + ")"
+		default:
+			explain += " (" + s.Type.String() + ")"
+		}
+		fmt.Fprintf(output, "    %-*s  %s\n", maxLen, s.usage(), strings.TrimSpace(explain))
+	}
+}
+
+// argSpec returns the spec of the i-th argument.
+func (cmd *Cmd) argSpec(i int) *ArgSpec {
+	specs := cmd.argSpecs
+	if i < len(specs) {
+		return &specs[i]
+	}
+	if len(specs) > 0 && specs[len(specs)-1].Variadic {
+		return &specs[len(specs)-1]
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+// Copyright 2014 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// The sources of the flag values, see Cmd.FlagSource.
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// config holds the values of a config file, the sections are
+// the cmd paths joined by ".", such as "db.migrate".
+type config struct {
+	path     string
+	err      error
+	sections map[string]map[string]string
+}
+
+// loadConfig loads c.ConfigFile, the result is cached by path.
+func (c *CmdSet) loadConfig() (*config, error) {
+	if c.ConfigFile == "" {
+		return nil, nil
+	}
+	if c.config != nil && c.config.path == c.ConfigFile {
+		return c.config, c.config.err
+	}
+
+	cf := &config{path: c.ConfigFile}
+	data, err := os.ReadFile(cf.path)
+	if os.IsNotExist(err) {
+		// An absent file is an empty one.
+		c.config = cf
+		return cf, nil
+	}
+	if err == nil {
+		if strings.EqualFold(filepath.Ext(cf.path), ".json") {
+			cf.sections, err = parseJSONConfig(data)
+		} else {
+			cf.sections, err = parseINIConfig(bytes.NewReader(data))
+		}
+	}
+	if err != nil {
+		cf.err = fmt.Errorf("config file %s: %v", cf.path, err)
+	}
+	c.config = cf
+	return cf, cf.err
+}
+
+// parseJSONConfig parses an object, the nested objects are the sections
+// of the sub cmds, such as {"db": {"host": "x", "migrate": {"dry-run": true}}}.
+func parseJSONConfig(data []byte) (map[string]map[string]string, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var root map[string]interface{}
+	if err := d.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	sections := make(map[string]map[string]string)
+	var walk func(section string, obj map[string]interface{}) error
+	walk = func(section string, obj map[string]interface{}) error {
+		for k, v := range obj {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				sub := k
+				if section != "" {
+					sub = section + "." + k
+				}
+				if err := walk(sub, v); err != nil {
+					return err
+				}
+				continue
+			case string:
+				setConfig(sections, section, k, v)
+			case json.Number, bool:
+				setConfig(sections, section, k, fmt.Sprint(v))
+			default:
+				return fmt.Errorf("unsupported value of %q in section %q", k, section)
+			}
+		}
+		return nil
+	}
+	if err := walk("", root); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// parseINIConfig parses "key = value" lines, the section "[db.migrate]"
+// holds the values of the sub cmd "db migrate". The lines starting with
+// "#" or ";" are comments.
+func parseINIConfig(r io.Reader) (map[string]map[string]string, error) {
+	sections := make(map[string]map[string]string)
+	section := ""
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("line %d: bad section", n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		default:
+			i := strings.Index(line, "=")
+			if i < 0 {
+				return nil, fmt.Errorf("line %d: missing \"=\"", n)
+			}
+			v := strings.TrimSpace(line[i+1:])
+			if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+				v = v[1 : len(v)-1]
+			}
+			setConfig(sections, section, strings.TrimSpace(line[:i]), v)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+func setConfig(sections map[string]map[string]string, section, k, v string) {
+	m := sections[section]
+	if m == nil {
+		m = make(map[string]string)
+		sections[section] = m
+	}
+	m[k] = v
+}
+
+// envName returns the environment variable name of the flag of the cmd
+// path, such as PREFIX_DB_MIGRATE_DRY_RUN, or PREFIX_FLAG for the global
+// flags (path is nil).
+func (c *CmdSet) envName(path []string, name string) string {
+	parts := append([]string{c.EnvPrefix}, path...)
+	parts = append(parts, name)
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.Join(parts, "_"))
+}
+
+// boundValue returns the value of the unset flag from the environment
+// or the config file, and its source.
+func (c *CmdSet) boundValue(path []string, name string, cf *config) (v string, source string, ok bool) {
+	if c.EnvPrefix != "" {
+		env := c.envName(path, name)
+		if v, ok := os.LookupEnv(env); ok {
+			return v, SourceEnv + " " + env, true
+		}
+	}
+	if cf != nil {
+		section := strings.Join(path, ".")
+		if v, ok := cf.sections[section][name]; ok {
+			return v, SourceFile + " " + cf.path, true
+		}
+	}
+	return "", "", false
+}
+
+// bindFlags fills the unset flags of fs (the flags of the cmd path) from
+// the environment and the config file, and returns the sources of all flags.
+func (c *CmdSet) bindFlags(fs *flag.FlagSet, path []string) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = SourceFlag
+	})
+	if c.EnvPrefix == "" && c.ConfigFile == "" {
+		return sources, nil
+	}
+
+	cf, err := c.loadConfig()
+	if err != nil {
+		return sources, err
+	}
+
+	var unset []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := sources[f.Name]; !ok {
+			unset = append(unset, f)
+		}
+	})
+	for _, f := range unset {
+		v, source, ok := c.boundValue(path, f.Name, cf)
+		if !ok {
+			continue
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			return sources, fmt.Errorf("invalid value %q for flag -%s from %s: %v",
+				v, f.Name, source, err)
+		}
+		sources[f.Name] = source
+	}
+	return sources, nil
+}
+
+// FlagSource returns the source of the named flag's effective value:
+// SourceFlag, SourceDefault, or SourceEnv / SourceFile followed by
+// the variable name / file path, such as "env APP_DB_HOST".
+//
+// Before parsing, it reports the source the value would come from.
+func (cmd *Cmd) FlagSource(name string) string {
+	_, source := cmd.set.effectiveValue(&cmd.FlagSet, cmd.Path(), cmd.sources, name)
+	return source
+}
+
+func (c *CmdSet) effectiveValue(fs *flag.FlagSet, path []string,
+	sources map[string]string, name string) (v string, source string) {
+
+	f := fs.Lookup(name)
+	if f == nil {
+		return "", ""
+	}
+	if source, ok := sources[name]; ok {
+		return f.Value.String(), source
+	}
+	if fs.Parsed() {
+		return f.Value.String(), SourceDefault
+	}
+	cf, _ := c.loadConfig()
+	if v, source, ok := c.boundValue(path, name, cf); ok {
+		return v, source
+	}
+	return f.Value.String(), SourceDefault
+}
+
+// printFlags is like fs.PrintDefaults, but also prints the bound
+// environment variable and the source of each flag's value.
+func (c *CmdSet) printFlags(fs *flag.FlagSet, path []string, sources map[string]string) {
+	if c.EnvPrefix == "" && c.ConfigFile == "" {
+		fs.PrintDefaults()
+		return
+	}
+
+	output := fs.Output()
+	fs.VisitAll(func(f *flag.Flag) {
+		var b strings.Builder
+		fmt.Fprintf(&b, "  -%s", f.Name)
+		name, usage := flag.UnquoteUsage(f)
+		if len(name) > 0 {
+			b.WriteString(" ")
+			b.WriteString(name)
+		}
+		if b.Len() <= 4 {
+			b.WriteString("\t")
+		} else {
+			b.WriteString("\n    \t")
+		}
+		b.WriteString(strings.ReplaceAll(usage, "\n", "\n    \t"))
+		if !isZeroValue(f, f.DefValue) {
+			if isStringFlag(f) {
+				fmt.Fprintf(&b, " (default %q)", f.DefValue)
+			} else {
+				fmt.Fprintf(&b, " (default %v)", f.DefValue)
+			}
+		}
+		if c.EnvPrefix != "" {
+			fmt.Fprintf(&b, " [env %s]", c.envName(path, f.Name))
+		}
+		v, source := c.effectiveValue(fs, path, sources, f.Name)
+		fmt.Fprintf(&b, "\n    \tvalue %q from %s", v, source)
+		fmt.Fprintln(output, b.String())
+	})
+}
+
+// isZeroValue reports whether value is the zero value of the flag's type,
+// as flag.PrintDefaults does.
+func isZeroValue(f *flag.Flag, value string) (zero bool) {
+	defer func() {
+		// String of the zero value may panic.
+		if recover() != nil {
+			zero = false
+		}
+	}()
+
+	typ := reflect.TypeOf(f.Value)
+	var z reflect.Value
+	if typ.Kind() == reflect.Pointer {
+		z = reflect.New(typ.Elem())
+	} else {
+		z = reflect.Zero(typ)
+	}
+	return value == z.Interface().(flag.Value).String()
+}
+
+// isStringFlag reports whether the flag holds a string,
+// its default value is quoted in help.
+func isStringFlag(f *flag.Flag) bool {
+	g, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	_, ok = g.Get().(string)
+	return ok
+}
@@ -7,6 +7,8 @@
 
 	Multi-cmd command-line format:
 		program command [arguments]
+	A cmd can also hold its own sub cmds, the format is
+		program command subcommand ... [arguments]
 
 	For example, the command-line of "go" is this type, its usage is
 		go command [arguments]
@@ -18,17 +20,43 @@
 	The Cmd structure returned contains flag.FlagSet, you can use
 	it to define flags for the new cmd. For information	about
 	how to define flags, see the documentation for flag.
+	Define a sub cmd using Cmd.NewCmd() or Cmd.NewCmdVar().
 
 	After all cmds are defined, call
 		cmdset.Parse()
 	to parse the command line into one of the defined cmds.
 	The	selected cmd is called winning cmd, you can obtain it by
-	calling cmdset.Winning().
+	calling cmdset.Winning(). If the winning cmd is a sub cmd,
+	it is the deepest matched one, and its path is available as
+	cmdset.WinningPath().
 
 	After parsing, the arguments after the cmd are available as
 	the	slice cmdset.Winning().Args() or individually as
 	cmdset.Winning().Arg(i). The arguments are indexed from 0
 	through cmdset.Winning().NArg()-1.
+
+	A cmd can be also matched by its Aliases, or by an unambiguous
+	prefix if CommandLine.PrefixMatch is set. For an unknown cmd,
+	the closest ones are suggested.
+
+	Instead of checking the winning cmd after parsing, you can set
+	the Run field of each cmd, then call
+		cmdset.Execute(ctx)
+	to parse the command line and run the winning cmd.
+
+	The positional arguments of a cmd can be specified by Cmd.SetArgs(),
+	they are validated by parsing, and available by name such as
+	cmdset.Winning().NamedArg(name).
+
+	The global flags shared by all cmds are defined in the FlagSet
+	cmdset.GlobalFlags(), they can appear before and after the cmd name.
+
+	The unset flags can be filled from the environment and a config
+	file, see CmdSet.EnvPrefix and CmdSet.ConfigFile.
+
+	The shell completion script can be generated by
+		cmdset.GenCompletion(w, shell)
+	it calls the program's hidden cmd "__complete" for the candidates.
 */
 package cmdset
 
@@ -36,14 +64,22 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"golang.org/x/net/context"
 	"io"
 	"os"
 	"sort"
+	"strings"
 )
 
 // ErrHelp is the error returned if the cmd help is invoked but no such cmd is defined.
 var ErrHelp = errors.New("cmd: help requested")
 
+// ErrCompleted is the error returned if the hidden completion cmd is invoked.
+// It is not a failure, the candidates have been printed and there is no
+// winning cmd, so the program should exit with status 0 (see ExitCode).
+// Parse never panics with it, and exits with status 0 with ExitOnError.
+var ErrCompleted = errors.New("cmd: completion requested")
+
 // ErrorHandling defines how to handle cmd parsing errors.
 type ErrorHandling int
 
@@ -61,33 +97,94 @@ type CmdSet struct {
 	// a custom error handler.
 	CustomHelp func()
 
+	// PrefixMatch enables matching a cmd by an unambiguous prefix of
+	// its name or aliases, such as "st" for "status".
+	PrefixMatch bool
+
+	// EnvPrefix enables filling the unset flags from the environment
+	// variables named PREFIX_CMD_FLAG, such as APP_DB_MIGRATE_DRY_RUN
+	// for the flag "dry-run" of the sub cmd "db migrate", or PREFIX_FLAG
+	// for the global flags.
+	EnvPrefix string
+
+	// ConfigFile enables filling the unset flags from the config file.
+	// It is a JSON file if the extension is ".json", or else an INI file,
+	// the section of a cmd is its path joined by ".", such as "db.migrate",
+	// the global flags are out of any section. A file which does not
+	// exist is treated as an empty one.
+	//
+	// The precedence is flag, then env, then file, then default.
+	ConfigFile string
+
 	name          string
 	parsed        bool
-	winning       string
+	winning       *Cmd
+	cmdHelped     bool // a cmd help is printed by "help command"
 	cmds          map[string]*Cmd
 	errorHandling ErrorHandling
 	output        io.Writer
+	stdout        io.Writer // for completion candidates
 	maxCmdLen     int
+	config        *config // the loaded ConfigFile
+
+	global           *flag.FlagSet
+	globalSources    map[string]string
+	globalCompleters map[string]Completer
 }
 
 // A Cmd represents the state of a cmd.
 type Cmd struct {
-	Name         string // name as it appears on command line
-	Explain      string // explain message
-	flag.FlagSet        // the flags of the cmd
+	Name         string   // name as it appears on command line
+	Explain      string   // explain message
+	Aliases      []string // alternative names on command line
+	flag.FlagSet          // the flags of the cmd
+
+	// Run is the function called by CmdSet.Execute when the cmd wins,
+	// args are the arguments after the flags.
+	Run func(ctx context.Context, cmd *Cmd, args []string) error
+
+	set       *CmdSet
+	parent    *Cmd
+	cmds      map[string]*Cmd // sub cmds
+	maxCmdLen int
+
+	flagCompleters map[string]Completer
+	argsCompleter  Completer
+
+	sources map[string]string // flag name to the source of its value
+
+	argSpecs  []ArgSpec
+	argValues map[string][]string
 }
 
+// Help prints a help message of the cmd. The message is printed by
+// the Usage field of the FlagSet, which may be changed to point to
+// a custom function.
 func (cmd *Cmd) Help() {
-	cmd.Parse([]string{"-help"})
+	if cmd.Usage == nil {
+		cmd.defaultHelp()
+		return
+	}
+	cmd.Usage()
 }
 
-// NewCmdVar defines a cmd with specified name, and explain string.
-// The argument cmd points to a Cmd variable in which to store the flags of the cmd.
-func (c *CmdSet) NewCmdVar(cmd *Cmd, name string, explain string) {
+func (c *CmdSet) initCmd(cmd *Cmd, parent *Cmd, name string, explain string) {
 	cmd.Name = name
 	cmd.Explain = explain
 	cmd.Init(name, flag.ErrorHandling(c.errorHandling))
 	cmd.SetOutput(c.output)
+	cmd.Usage = cmd.defaultHelp
+	cmd.set = c
+	cmd.parent = parent
+	cmd.cmds = make(map[string]*Cmd)
+	cmd.maxCmdLen = 10
+	cmd.flagCompleters = make(map[string]Completer)
+}
+
+// NewCmdVar defines a cmd with specified name, and explain string.
+// The argument cmd points to a Cmd variable in which to store the flags of the cmd.
+func (c *CmdSet) NewCmdVar(cmd *Cmd, name string, explain string) {
+	c.initCmd(cmd, nil, name, explain)
 	c.cmds[name] = cmd
 	if len(name) > c.maxCmdLen {
 		c.maxCmdLen = len(name)
@@ -114,6 +211,61 @@ func NewCmd(name string, explain string) *Cmd {
 	return CommandLine.NewCmd(name, explain)
 }
 
+// NewCmdVar defines a sub cmd with specified name, and explain string.
+// The argument sub points to a Cmd variable in which to store the flags of the sub cmd.
+func (cmd *Cmd) NewCmdVar(sub *Cmd, name string, explain string) {
+	cmd.set.initCmd(sub, cmd, name, explain)
+	cmd.cmds[name] = sub
+	if len(name) > cmd.maxCmdLen {
+		cmd.maxCmdLen = len(name)
+	}
+}
+
+// NewCmd defines a sub cmd with specified name, and explain string.
+// The return value is the address of a Cmd variable in which to store the flags of the sub cmd.
+func (cmd *Cmd) NewCmd(name string, explain string) *Cmd {
+	sub := new(Cmd)
+	cmd.NewCmdVar(sub, name, explain)
+	return sub
+}
+
+// SubCmd returns the Cmd structure of the named (or aliased) sub cmd,
+// returning nil if none exists.
+func (cmd *Cmd) SubCmd(name string) *Cmd {
+	return findCmd(cmd.cmds, name)
+}
+
+// HasSubCmds reports whether the cmd has sub cmds.
+func (cmd *Cmd) HasSubCmds() bool {
+	return len(cmd.cmds) > 0
+}
+
+// VisitSubCmds visits the sub cmds in lexicographical order, calling fn for each.
+func (cmd *Cmd) VisitSubCmds(fn func(*Cmd)) {
+	for _, sub := range sortCmds(cmd.cmds) {
+		fn(sub)
+	}
+}
+
+// Parent returns the parent cmd, returning nil if cmd is a top-level cmd.
+func (cmd *Cmd) Parent() *Cmd {
+	return cmd.parent
+}
+
+// Path returns the names from the top-level cmd to cmd.
+func (cmd *Cmd) Path() []string {
+	var path []string
+	for p := cmd; p != nil; p = p.parent {
+		path = append([]string{p.Name}, path...)
+	}
+	return path
+}
+
+// fullName returns the program name followed by the path.
+func (cmd *Cmd) fullName() string {
+	return cmd.set.name + " " + strings.Join(cmd.Path(), " ")
+}
+
 // sortCmds returns the cmds as a slice in lexicographical sorted order.
 func sortCmds(cmds map[string]*Cmd) []*Cmd {
 	list := make(sort.StringSlice, len(cmds))
@@ -137,8 +289,14 @@ func (c *CmdSet) SetOutput(output io.Writer) {
 		output = os.Stderr
 	}
 	c.output = output
-	for _, cmd := range c.cmds {
+	c.global.SetOutput(output)
+	setCmdsOutput(c.cmds, output)
+}
+
+func setCmdsOutput(cmds map[string]*Cmd, output io.Writer) {
+	for _, cmd := range cmds {
 		cmd.SetOutput(output)
+		setCmdsOutput(cmd.cmds, output)
 	}
 }
 
@@ -148,19 +306,33 @@ func SetOutput(output io.Writer) {
 	CommandLine.SetOutput(output)
 }
 
-// Winning returns the winning Cmd structure.
+// Winning returns the winning Cmd structure, it is the deepest matched cmd.
 func (c *CmdSet) Winning() *Cmd {
-	return c.Lookup(c.winning)
+	return c.winning
 }
 
-// Winning returns the winning Cmd structure.
+// Winning returns the winning Cmd structure, it is the deepest matched cmd.
 func Winning() *Cmd {
 	return CommandLine.Winning()
 }
 
-// Lookup returns the Cmd structure of the named cmd, returning nil if none exists.
+// WinningPath returns the names from the top-level cmd to the winning cmd.
+func (c *CmdSet) WinningPath() []string {
+	if c.winning == nil {
+		return nil
+	}
+	return c.winning.Path()
+}
+
+// WinningPath returns the names from the top-level cmd to the winning cmd.
+func WinningPath() []string {
+	return CommandLine.WinningPath()
+}
+
+// Lookup returns the Cmd structure of the named (or aliased) cmd,
+// returning nil if none exists.
 func (c *CmdSet) Lookup(name string) *Cmd {
-	return c.cmds[name]
+	return findCmd(c.cmds, name)
 }
 
 // Lookup returns the Cmd structure of the named cmd, returning nil if none exists.
@@ -168,6 +340,27 @@ func Lookup(name string) *Cmd {
 	return CommandLine.Lookup(name)
 }
 
+// LookupPath returns the Cmd structure of the cmd path, such as
+// "db", "migrate", "up", returning nil if none exists.
+func (c *CmdSet) LookupPath(path ...string) *Cmd {
+	cmds := c.cmds
+	var cmd *Cmd
+	for _, name := range path {
+		cmd = findCmd(cmds, name)
+		if cmd == nil {
+			return nil
+		}
+		cmds = cmd.cmds
+	}
+	return cmd
+}
+
+// LookupPath returns the Cmd structure of the cmd path, such as
+// "db", "migrate", "up", returning nil if none exists.
+func LookupPath(path ...string) *Cmd {
+	return CommandLine.LookupPath(path...)
+}
+
 // Visit visits the cmds in lexicographical order, calling fn for each.
 func (c *CmdSet) Visit(fn func(*Cmd)) {
 	for _, cmd := range sortCmds(c.cmds) {
@@ -187,6 +380,14 @@ func (c *CmdSet) failf(format string, a ...interface{}) error {
 	return err
 }
 
+// cmdFailf is like failf, but prints the help message of cmd.
+func (c *CmdSet) cmdFailf(cmd *Cmd, format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	fmt.Fprintln(c.output, err)
+	cmd.Help()
+	return err
+}
+
 func (c *CmdSet) help() {
 	if c.CustomHelp == nil {
 		c.defaultHelp()
@@ -199,28 +400,105 @@ func (c *CmdSet) defaultHelp() {
 	fmt.Fprintln(c.output)
 	fmt.Fprintln(c.output, "Usage:")
 	fmt.Fprintln(c.output)
-	fmt.Fprintf(c.output, "%s command [arguments]\n", c.name)
+	if hasFlags(c.global) {
+		fmt.Fprintf(c.output, "%s [global flags] command [arguments]\n", c.name)
+	} else {
+		fmt.Fprintf(c.output, "%s command [arguments]\n", c.name)
+	}
 	fmt.Fprintln(c.output)
 	fmt.Fprintln(c.output, "The commands are:")
 	fmt.Fprintln(c.output)
-	c.Visit(func(cmd *Cmd) {
+	printCmds(c.output, c.cmds, c.maxCmdLen)
+	fmt.Fprintln(c.output)
+	c.printGlobalFlags()
+	fmt.Fprintf(c.output, "Use \"%s help [command]\" for more information about a command.", c.name)
+	fmt.Fprintln(c.output)
+	fmt.Fprintln(c.output)
+}
+
+func printCmds(output io.Writer, cmds map[string]*Cmd, maxCmdLen int) {
+	for _, cmd := range sortCmds(cmds) {
 		n := cmd.Name
-		if len(n) < c.maxCmdLen {
-			b := make([]byte, c.maxCmdLen-len(n))
+		if len(n) < maxCmdLen {
+			b := make([]byte, maxCmdLen-len(n))
 			for i := range b {
 				b[i] = ' '
 			}
 			n = n + string(b)
 		}
-		fmt.Fprintf(c.output, "    %s  %s\n", n, cmd.Explain)
-	})
-	fmt.Fprintln(c.output)
-	fmt.Fprintf(c.output, "Use \"%s help [command]\" for more information about a command.", c.name)
+		explain := cmd.Explain
+		if len(cmd.Aliases) > 0 {
+			explain += " (aliases: " + strings.Join(cmd.Aliases, ", ") + ")"
+		}
+		fmt.Fprintf(output, "    %s  %s\n", n, explain)
+	}
+}
+
+func (cmd *Cmd) defaultHelp() {
+	output := cmd.Output()
+
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "Usage:")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, cmd.UsageLine())
+	fmt.Fprintln(output)
+	if cmd.Explain != "" {
+		fmt.Fprintln(output, cmd.Explain)
+		fmt.Fprintln(output)
+	}
+	if cmd.HasSubCmds() {
+		fmt.Fprintln(output, "The commands are:")
+		fmt.Fprintln(output)
+		printCmds(output, cmd.cmds, cmd.maxCmdLen)
+		fmt.Fprintln(output)
+	}
+	if len(cmd.argSpecs) > 0 && !cmd.HasSubCmds() {
+		fmt.Fprintln(output, "The arguments are:")
+		fmt.Fprintln(output)
+		cmd.printArgs()
+		fmt.Fprintln(output)
+	}
+	if hasFlags(&cmd.FlagSet) {
+		fmt.Fprintln(output, "The flags are:")
+		fmt.Fprintln(output)
+		cmd.set.printFlags(&cmd.FlagSet, cmd.Path(), cmd.sources)
+		fmt.Fprintln(output)
+	}
+	cmd.set.printGlobalFlags()
+	if cmd.HasSubCmds() {
+		fmt.Fprintf(output, "Use \"%s help %s [command]\" for more information about a command.",
+			cmd.set.name, strings.Join(cmd.Path(), " "))
+		fmt.Fprintln(output)
+		fmt.Fprintln(output)
+	}
+}
+
+func (c *CmdSet) printGlobalFlags() {
+	if !hasFlags(c.global) {
+		return
+	}
+	fmt.Fprintln(c.output, "The global flags are:")
 	fmt.Fprintln(c.output)
+	c.printFlags(c.global, nil, c.globalSources)
 	fmt.Fprintln(c.output)
 }
 
+func hasFlags(fs *flag.FlagSet) bool {
+	has := false
+	fs.VisitAll(func(*flag.Flag) { has = true })
+	return has
+}
+
 func (c *CmdSet) parseCmd(arguments []string) error {
+	c.cmdHelped = false
+	if err := c.global.Parse(arguments); err != nil {
+		if err == flag.ErrHelp {
+			return ErrHelp
+		}
+		return err
+	}
+	arguments = c.global.Args()
+
 	if len(arguments) == 0 {
 		c.help()
 		return ErrHelp
@@ -228,8 +506,8 @@ func (c *CmdSet) parseCmd(arguments []string) error {
 
 	name := arguments[0]
 
-	cmd, alreadythere := c.cmds[name]
-	if !alreadythere {
+	cmd := findCmd(c.cmds, name)
+	if cmd == nil {
 		// special case for nice help message.
 		// CmdSet help
 		if name == "-h" || name == "-help" || name == "--help" {
@@ -237,26 +515,77 @@ func (c *CmdSet) parseCmd(arguments []string) error {
 			return ErrHelp
 		}
 		if name == "help" {
-			if len(arguments) == 1 {
-				c.help()
-				return ErrHelp
-			}
-			name2 := arguments[1]
-			cmd2, alreadythere2 := c.cmds[name2]
-			if !alreadythere2 {
-				c.help()
-				return ErrHelp
-			}
-			// Cmd Help
-			cmd2.Help()
+			c.helpPath(arguments[1:])
 			return ErrHelp
 		}
+		// Hidden cmd for shell completion.
+		if name == completeCmd {
+			c.complete(arguments[1:])
+			return ErrCompleted
+		}
 
-		return c.failf("unknown cmd: %s", name)
+		var err error
+		cmd, err = c.matchCmd(nil, c.cmds, name)
+		if err != nil {
+			return err
+		}
 	}
 
-	c.winning = name
-	return cmd.Parse(arguments[1:])
+	return c.parseCmdTree(cmd, arguments[1:])
+}
+
+// helpPath prints the help message of the deepest matched cmd of path.
+func (c *CmdSet) helpPath(path []string) {
+	cmds := c.cmds
+	var cmd *Cmd
+	for _, name := range path {
+		sub := findCmd(cmds, name)
+		if sub == nil {
+			break
+		}
+		cmd = sub
+		cmds = cmd.cmds
+	}
+	if cmd == nil {
+		c.help()
+		return
+	}
+	// Cmd Help
+	cmd.Help()
+	c.cmdHelped = true
+}
+
+// parseCmdTree parses the flags of cmd, then walks down the sub cmds.
+func (c *CmdSet) parseCmdTree(cmd *Cmd, arguments []string) error {
+	for {
+		c.winning = cmd
+		var err error
+		arguments, err = c.parseGlobals(cmd, arguments)
+		if err != nil {
+			return err
+		}
+		if err := cmd.Parse(arguments); err != nil {
+			return err
+		}
+		if !cmd.HasSubCmds() {
+			if err := c.bindAll(); err != nil {
+				return err
+			}
+			return c.parseArgs(cmd)
+		}
+
+		arguments = cmd.Args()
+		if len(arguments) == 0 {
+			cmd.Help()
+			return ErrHelp
+		}
+		sub, err := c.matchCmd(cmd, cmd.cmds, arguments[0])
+		if err != nil {
+			return err
+		}
+		cmd = sub
+		arguments = arguments[1:]
+	}
 }
 
 // Parse parses cmd definitions from the argument list, the first argument
@@ -265,11 +594,21 @@ func (c *CmdSet) parseCmd(arguments []string) error {
 func (c *CmdSet) Parse(arguments []string) error {
 	c.parsed = true
 	err := c.parseCmd(arguments)
+	if err == ErrCompleted {
+		if c.errorHandling == ExitOnError {
+			os.Exit(0)
+		}
+		return err
+	}
 	if err != nil {
 		switch c.errorHandling {
 		case ContinueOnError:
 			return err
 		case ExitOnError:
+			if err == ErrHelp && c.cmdHelped {
+				// "help command" succeeds, like "command -h".
+				os.Exit(0)
+			}
 			os.Exit(2)
 		case PanicOnError:
 			panic(err)
@@ -324,5 +663,10 @@ func (c *CmdSet) Init(name string, errorHandling ErrorHandling) {
 	c.errorHandling = errorHandling
 	c.cmds = make(map[string]*Cmd)
 	c.output = os.Stderr
+	c.stdout = os.Stdout
 	c.maxCmdLen = 10
+	c.global = flag.NewFlagSet(name, flag.ContinueOnError)
+	c.global.SetOutput(c.output)
+	c.global.Usage = c.help
+	c.globalCompleters = make(map[string]Completer)
 }
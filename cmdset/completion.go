@@ -0,0 +1,283 @@
+// Copyright 2014 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdset
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The hidden cmd called by the completion scripts, its arguments are
+// the words after the program name, the last one is the word being
+// completed. It prints the candidates one per line.
+const completeCmd = "__complete"
+
+// Completer returns the candidates of a value, prefix is the part
+// already typed. The candidates not starting with prefix are ignored.
+type Completer func(prefix string) []string
+
+// SetFlagCompleter registers the completer of the named flag's value.
+func (cmd *Cmd) SetFlagCompleter(name string, fn Completer) {
+	cmd.flagCompleters[name] = fn
+}
+
+// SetGlobalFlagCompleter registers the completer of the named global flag's value.
+func (c *CmdSet) SetGlobalFlagCompleter(name string, fn Completer) {
+	c.globalCompleters[name] = fn
+}
+
+// SetArgsCompleter registers the completer of the arguments.
+func (cmd *Cmd) SetArgsCompleter(fn Completer) {
+	cmd.argsCompleter = fn
+}
+
+// GenCompletion writes the completion script of the shell,
+// shell can be "bash", "zsh" or "fish".
+func (c *CmdSet) GenCompletion(w io.Writer, shell string) error {
+	prog := filepath.Base(c.name)
+	fn := "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, prog) + "_complete"
+
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+	r := strings.NewReplacer("{{prog}}", prog, "{{fn}}", fn, "{{cmd}}", completeCmd)
+	_, err := io.WriteString(w, r.Replace(script))
+	return err
+}
+
+// GenCompletion writes the completion script of the shell,
+// shell can be "bash", "zsh" or "fish".
+func GenCompletion(w io.Writer, shell string) error {
+	return CommandLine.GenCompletion(w, shell)
+}
+
+const bashCompletion = `# bash completion for {{prog}}
+{{fn}}() {
+    local IFS=$'\n'
+    COMPREPLY=($({{prog}} {{cmd}} "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F {{fn}} {{prog}}
+`
+
+const zshCompletion = `#compdef {{prog}}
+# zsh completion for {{prog}}
+{{fn}}() {
+    local -a candidates
+    candidates=("${(@f)$({{prog}} {{cmd}} "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    compadd -- $candidates
+}
+compdef {{fn}} {{prog}}
+`
+
+const fishCompletion = `# fish completion for {{prog}}
+function {{fn}}
+    set -l tokens (commandline -opc) (commandline -ct)
+    {{prog}} {{cmd}} $tokens[2..-1] 2>/dev/null
+end
+complete -c {{prog}} -f -a '({{fn}})'
+`
+
+func (c *CmdSet) complete(words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	for _, s := range c.candidates(words[:len(words)-1], words[len(words)-1]) {
+		fmt.Fprintln(c.stdout, s)
+	}
+}
+
+// candidates returns the sorted candidates of cur, words are the
+// complete words before it.
+func (c *CmdSet) candidates(words []string, cur string) []string {
+	var (
+		cmd      *Cmd
+		cmds     = c.cmds
+		helpMode bool
+		valueOf  *flag.Flag // the flag expecting a value
+		nargs    int        // the number of arguments
+		eqValue  bool       // the next word is the value after "="
+	)
+	for i, w := range words {
+		if eqValue {
+			eqValue = false
+			continue
+		}
+		// bash splits "-name=value" into "-name", "=" and "value",
+		// see COMP_WORDBREAKS.
+		if w == "=" && i > 0 && isFlagWord(words[i-1]) {
+			valueOf = nil
+			eqValue = true
+			continue
+		}
+		if valueOf != nil {
+			valueOf = nil
+			continue
+		}
+		if i == 0 && w == "help" {
+			helpMode = true
+			continue
+		}
+		if !helpMode && strings.HasPrefix(w, "-") {
+			valueOf = c.flagExpectingValue(cmd, w)
+			continue
+		}
+		if sub := findCmd(cmds, w); sub != nil {
+			cmd = sub
+			cmds = cmd.cmds
+			continue
+		}
+		if cmd == nil || helpMode {
+			// Unknown cmd.
+			return nil
+		}
+		// An argument, no more sub cmds.
+		cmds = nil
+		nargs++
+	}
+
+	// The value of "-name=" split by bash, it is completed alone,
+	// because bash replaces only the part after "=".
+	n := len(words)
+	if !helpMode && (eqValue || cur == "=" && n > 0 && isFlagWord(words[n-1])) {
+		name := words[n-1]
+		if eqValue {
+			name = words[n-2]
+		} else {
+			cur = ""
+		}
+		return c.completeValue(cmd, strings.TrimLeft(name, "-"), cur)
+	}
+
+	var cands []string
+	switch {
+	case valueOf != nil:
+		cands = c.completeValue(cmd, valueOf.Name, cur)
+	case !helpMode && strings.HasPrefix(cur, "-"):
+		cands = c.completeFlag(cmd, cur)
+	default:
+		for name := range cmds {
+			cands = append(cands, name)
+		}
+		if cmd == nil && !helpMode {
+			cands = append(cands, "help")
+		}
+		if cmd != nil && !helpMode {
+			if cmd.argsCompleter != nil {
+				cands = append(cands, cmd.argsCompleter(cur)...)
+			} else if s := cmd.argSpec(nargs); s != nil && s.Type == ArgEnum {
+				cands = append(cands, s.Enum...)
+			}
+		}
+	}
+	return filterPrefix(cands, cur)
+}
+
+// isFlagWord reports whether w is a flag without value, such as "-name".
+func isFlagWord(w string) bool {
+	return len(w) > 1 && w[0] == '-' && w != "--" && !strings.Contains(w, "=")
+}
+
+// lookupFlag returns the named flag of cmd, or the global one.
+// cmd is nil before the cmd name.
+func (c *CmdSet) lookupFlag(cmd *Cmd, name string) *flag.Flag {
+	if cmd != nil {
+		if f := cmd.Lookup(name); f != nil {
+			return f
+		}
+	}
+	return c.global.Lookup(name)
+}
+
+// flagExpectingValue returns the flag of w if its value is the next word.
+func (c *CmdSet) flagExpectingValue(cmd *Cmd, w string) *flag.Flag {
+	name := strings.TrimLeft(w, "-")
+	if name == "" || strings.Contains(name, "=") {
+		return nil
+	}
+	f := c.lookupFlag(cmd, name)
+	if f == nil || isBoolFlag(f) {
+		return nil
+	}
+	return f
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// completeFlag completes a flag name, or a flag value in "-name=value" form.
+func (c *CmdSet) completeFlag(cmd *Cmd, cur string) []string {
+	dashes := "-"
+	if strings.HasPrefix(cur, "--") {
+		dashes = "--"
+	}
+	name := strings.TrimPrefix(cur, dashes)
+
+	if i := strings.Index(name, "="); i >= 0 {
+		prefix := dashes + name[:i+1]
+		var cands []string
+		for _, v := range c.completeValue(cmd, name[:i], name[i+1:]) {
+			cands = append(cands, prefix+v)
+		}
+		return cands
+	}
+
+	var cands []string
+	add := func(f *flag.Flag) {
+		cands = append(cands, dashes+f.Name)
+	}
+	if cmd != nil {
+		cmd.VisitAll(add)
+	}
+	c.global.VisitAll(func(f *flag.Flag) {
+		if cmd == nil || cmd.Lookup(f.Name) == nil {
+			add(f)
+		}
+	})
+	return cands
+}
+
+func (c *CmdSet) completeValue(cmd *Cmd, name string, cur string) []string {
+	if cmd != nil && cmd.Lookup(name) != nil {
+		if fn := cmd.flagCompleters[name]; fn != nil {
+			return filterPrefix(fn(cur), cur)
+		}
+	} else if fn := c.globalCompleters[name]; fn != nil {
+		return filterPrefix(fn(cur), cur)
+	}
+	if f := c.lookupFlag(cmd, name); f != nil && isBoolFlag(f) {
+		return filterPrefix([]string{"true", "false"}, cur)
+	}
+	return nil
+}
+
+func filterPrefix(cands []string, prefix string) []string {
+	var r []string
+	for _, s := range cands {
+		if strings.HasPrefix(s, prefix) {
+			r = append(r, s)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
@@ -0,0 +1,136 @@
+// Copyright 2014 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdset
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+)
+
+// ExitCoder is implemented by the errors which choose a specific exit status.
+type ExitCoder interface {
+	error
+	ExitCode() int
+}
+
+// ExitError is an error with a specific exit status.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+// NewExitError returns an ExitError, err may be nil for a silent exit.
+func NewExitError(code int, err error) *ExitError {
+	return &ExitError{Code: code, Err: err}
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return ""
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// ExitCode maps an error returned by Execute to an exit status:
+// 0 for nil and ErrCompleted, 2 for ErrHelp and the parsing errors,
+// the chosen one for an ExitCoder, and 1 for the others.
+func ExitCode(err error) int {
+	if err == nil || err == ErrCompleted {
+		return 0
+	}
+	var ec ExitCoder
+	if errors.As(err, &ec) {
+		return ec.ExitCode()
+	}
+	var pe *parseError
+	if errors.Is(err, ErrHelp) || errors.As(err, &pe) {
+		return 2
+	}
+	return 1
+}
+
+// parseError wraps the errors occurred while parsing.
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
+
+// Execute parses the argument list, then calls the Run function of the
+// winning cmd with ctx. If the winning cmd has no Run function, its help
+// message is printed and ErrHelp is returned.
+//
+// The error is handled according to the error handling property, with
+// ExitOnError, the program exits with the status returned by ExitCode.
+// The hidden completion cmd is a success, Execute returns nil after
+// printing the candidates.
+func (c *CmdSet) Execute(ctx context.Context, arguments []string) error {
+	err := c.execute(ctx, arguments)
+	if err == ErrCompleted {
+		return nil
+	}
+	if err != nil {
+		switch c.errorHandling {
+		case ContinueOnError:
+			return err
+		case ExitOnError:
+			if err == ErrHelp && c.cmdHelped {
+				// "help command" succeeds, like "command -h".
+				os.Exit(0)
+			}
+			os.Exit(ExitCode(err))
+		case PanicOnError:
+			panic(err)
+		}
+	}
+	return nil
+}
+
+// Execute parses the command-line (os.Args[1:]), then calls the Run
+// function of the winning cmd with ctx.
+func Execute(ctx context.Context) {
+	// Ignore errors; CommandLine is set for ExitOnError.
+	CommandLine.Execute(ctx, os.Args[1:])
+}
+
+func (c *CmdSet) execute(ctx context.Context, arguments []string) error {
+	c.parsed = true
+	if err := c.parseCmd(arguments); err != nil {
+		if err == ErrHelp || err == ErrCompleted {
+			return err
+		}
+		return &parseError{err}
+	}
+
+	cmd := c.winning
+	if cmd.Run == nil {
+		cmd.Help()
+		return ErrHelp
+	}
+
+	err := cmd.Run(ctx, cmd, cmd.Args())
+	if err == ErrHelp {
+		cmd.Help()
+	} else if err != nil && err.Error() != "" {
+		fmt.Fprintf(c.output, "%s: %v\n", cmd.fullName(), err)
+	}
+	return err
+}
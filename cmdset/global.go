@@ -0,0 +1,98 @@
+// Copyright 2014 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdset
+
+import (
+	"flag"
+	"strings"
+)
+
+// GlobalFlags returns the global flags shared by all cmds, such as
+// -config or -v. They can appear before the cmd name, and also after
+// it where the cmd does not define a flag of the same name.
+func (c *CmdSet) GlobalFlags() *flag.FlagSet {
+	return c.global
+}
+
+// GlobalFlags returns the global flags shared by all cmds.
+func GlobalFlags() *flag.FlagSet {
+	return CommandLine.GlobalFlags()
+}
+
+// GlobalFlags returns the global flags of the CmdSet which the cmd belongs to.
+func (cmd *Cmd) GlobalFlags() *flag.FlagSet {
+	return cmd.set.global
+}
+
+// GlobalFlagSource is like Cmd.FlagSource, but for the named global flag.
+func (c *CmdSet) GlobalFlagSource(name string) string {
+	_, source := c.effectiveValue(c.global, nil, c.globalSources, name)
+	return source
+}
+
+// parseGlobals parses the global flags out of the leading flags of
+// arguments, the flags defined by cmd are kept, as well as the unknown
+// ones. It returns the remaining arguments.
+func (c *CmdSet) parseGlobals(cmd *Cmd, arguments []string) ([]string, error) {
+	rest := make([]string, 0, len(arguments))
+	for i := 0; i < len(arguments); i++ {
+		s := arguments[i]
+		if len(s) < 2 || s[0] != '-' || s == "--" {
+			// The flags end.
+			return append(rest, arguments[i:]...), nil
+		}
+
+		name := strings.TrimPrefix(s[1:], "-")
+		value, hasValue := "", false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+
+		g := c.global.Lookup(name)
+		if f := cmd.Lookup(name); f != nil || g == nil {
+			rest = append(rest, s)
+			if f != nil && !hasValue && !isBoolFlag(f) && i+1 < len(arguments) {
+				i++
+				rest = append(rest, arguments[i])
+			}
+			continue
+		}
+
+		switch {
+		case hasValue:
+		case isBoolFlag(g):
+			value = "true"
+		case i+1 < len(arguments):
+			i++
+			value = arguments[i]
+		default:
+			return nil, c.cmdFailf(cmd, "flag needs an argument: -%s", name)
+		}
+		if err := c.global.Set(name, value); err != nil {
+			return nil, c.cmdFailf(cmd, "invalid value %q for flag -%s: %v", value, name, err)
+		}
+	}
+	return rest, nil
+}
+
+// bindAll fills the unset global flags, then the unset flags of the
+// cmds from the top-level one to the winning one.
+func (c *CmdSet) bindAll() error {
+	var err error
+	if c.globalSources, err = c.bindFlags(c.global, nil); err != nil {
+		return c.failf("%v", err)
+	}
+
+	var path []*Cmd
+	for cmd := c.winning; cmd != nil; cmd = cmd.parent {
+		path = append([]*Cmd{cmd}, path...)
+	}
+	for _, cmd := range path {
+		if cmd.sources, err = c.bindFlags(&cmd.FlagSet, cmd.Path()); err != nil {
+			return c.cmdFailf(cmd, "%v", err)
+		}
+	}
+	return nil
+}
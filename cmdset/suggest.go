@@ -0,0 +1,164 @@
+// Copyright 2014 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmdset
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// findCmd returns the cmd of the name or alias, returning nil if none exists.
+func findCmd(cmds map[string]*Cmd, name string) *Cmd {
+	if cmd, ok := cmds[name]; ok {
+		return cmd
+	}
+	for _, cmd := range cmds {
+		for _, alias := range cmd.Aliases {
+			if alias == name {
+				return cmd
+			}
+		}
+	}
+	return nil
+}
+
+// matchCmd matches name in cmds (the sub cmds of parent, or the top-level
+// cmds if parent is nil), by an unambiguous prefix if enabled. If no cmd
+// matches, it fails with the suggestions.
+func (c *CmdSet) matchCmd(parent *Cmd, cmds map[string]*Cmd, name string) (*Cmd, error) {
+	if cmd := findCmd(cmds, name); cmd != nil {
+		return cmd, nil
+	}
+
+	full := name
+	if parent != nil {
+		full = strings.Join(parent.Path(), " ") + " " + name
+	}
+
+	if c.PrefixMatch && name != "" {
+		var matched []*Cmd
+		for _, cmd := range sortCmds(cmds) {
+			for _, n := range append([]string{cmd.Name}, cmd.Aliases...) {
+				if strings.HasPrefix(n, name) {
+					matched = append(matched, cmd)
+					break
+				}
+			}
+		}
+		if len(matched) == 1 {
+			return matched[0], nil
+		}
+		if len(matched) > 1 {
+			names := make([]string, len(matched))
+			for i, cmd := range matched {
+				names[i] = cmd.Name
+			}
+			return nil, c.failCmd(parent, fmt.Errorf("ambiguous cmd: %s, could be: %s",
+				full, strings.Join(names, ", ")))
+		}
+	}
+
+	err := fmt.Errorf("unknown cmd: %s", full)
+	if sugs := suggestCmds(cmds, name); len(sugs) > 0 {
+		fmt.Fprintln(c.output, err)
+		fmt.Fprintln(c.output)
+		if len(sugs) == 1 {
+			fmt.Fprintln(c.output, "Did you mean this?")
+		} else {
+			fmt.Fprintln(c.output, "Did you mean one of these?")
+		}
+		for _, s := range sugs {
+			fmt.Fprintf(c.output, "\t%s\n", s)
+		}
+		c.helpOf(parent)
+		return nil, err
+	}
+	return nil, c.failCmd(parent, err)
+}
+
+// failCmd prints err and the help message of cmd (or of c if cmd is nil).
+func (c *CmdSet) failCmd(cmd *Cmd, err error) error {
+	if cmd == nil {
+		return c.failf("%v", err)
+	}
+	return c.cmdFailf(cmd, "%v", err)
+}
+
+func (c *CmdSet) helpOf(cmd *Cmd) {
+	if cmd == nil {
+		c.help()
+		return
+	}
+	cmd.Help()
+}
+
+// Suggestions returns the names of the top-level cmds close to name.
+func (c *CmdSet) Suggestions(name string) []string {
+	return suggestCmds(c.cmds, name)
+}
+
+// Suggestions returns the names of the sub cmds close to name.
+func (cmd *Cmd) Suggestions(name string) []string {
+	return suggestCmds(cmd.cmds, name)
+}
+
+// suggestCmds returns the names of cmds whose name or aliases are close
+// to name by edit distance, the closest first.
+func suggestCmds(cmds map[string]*Cmd, name string) []string {
+	maxDist := len(name) / 3
+	if maxDist < 2 {
+		maxDist = 2
+	}
+
+	type sug struct {
+		name string
+		dist int
+	}
+	var sugs []sug
+	for _, cmd := range sortCmds(cmds) {
+		best := -1
+		for _, n := range append([]string{cmd.Name}, cmd.Aliases...) {
+			d := editDistance(strings.ToLower(n), strings.ToLower(name))
+			if best < 0 || d < best {
+				best = d
+			}
+		}
+		if best <= maxDist {
+			sugs = append(sugs, sug{cmd.Name, best})
+		}
+	}
+	sort.SliceStable(sugs, func(i, j int) bool {
+		return sugs[i].dist < sugs[j].dist
+	})
+
+	names := make([]string, len(sugs))
+	for i, s := range sugs {
+		names[i] = s.name
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -14,7 +14,7 @@ import (
 // RedisPool is a contexted redis pool.
 type RedisPool struct {
 	p      *Pool
-	concur chanutil.Semaphore
+	concur *chanutil.WeightedSemaphore
 }
 
 func NewRedisPool(
@@ -40,7 +40,7 @@ func NewRedisPool(
 	p := &RedisPool{}
 	p.p = rp
 	if maxConcurrent > 0 {
-		p.concur = chanutil.NewSemaphore(maxConcurrent)
+		p.concur = chanutil.NewWeightedSemaphore(maxConcurrent)
 	}
 	return p
 }
@@ -50,13 +50,7 @@ func (p *RedisPool) acquireConn(ctx context.Context) error {
 		return nil
 	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	// Acquire
-	case p.concur <- struct{}{}:
-		return nil
-	}
+	return p.concur.AcquireCtx(ctx, 1)
 }
 
 func (p *RedisPool) releaseConn() {
@@ -64,7 +58,25 @@ func (p *RedisPool) releaseConn() {
 		return
 	}
 
-	<-p.concur
+	p.concur.Release(1)
+}
+
+// Concurrency returns the semaphore which limits the concurrency, it can
+// be used for monitoring and debugging. It returns nil if p was
+// created with no limit on concurrency.
+func (p *RedisPool) Concurrency() *chanutil.WeightedSemaphore {
+	return p.concur
+}
+
+// SetMaxConcurrent changes the concurrency limit at runtime, a lowered
+// limit takes effect as the running calls finish. It has no effect if
+// p was created with no limit on concurrency.
+func (p *RedisPool) SetMaxConcurrent(maxConcurrent int) {
+	if p.concur == nil || maxConcurrent <= 0 {
+		return
+	}
+
+	p.concur.SetLimit(maxConcurrent)
 }
 
 type proxyConn struct {
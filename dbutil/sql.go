@@ -85,7 +85,7 @@ func (tx *SQLTx) Rollback() error {
 // SQLDB is a contexted sql DB.
 type SQLDB struct {
 	db     *DB
-	concur chanutil.Semaphore
+	concur *chanutil.WeightedSemaphore
 }
 
 func NewSQLDB(db *DB, maxConcurrent int) *SQLDB {
@@ -99,7 +99,7 @@ func NewSQLDB(db *DB, maxConcurrent int) *SQLDB {
 	d := &SQLDB{}
 	d.db = db
 	if maxConcurrent > 0 {
-		d.concur = chanutil.NewSemaphore(maxConcurrent)
+		d.concur = chanutil.NewWeightedSemaphore(maxConcurrent)
 	}
 	return d
 }
@@ -109,13 +109,7 @@ func (d *SQLDB) acquireConn(ctx context.Context) error {
 		return nil
 	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	// Acquire
-	case d.concur <- struct{}{}:
-		return nil
-	}
+	return d.concur.AcquireCtx(ctx, 1)
 }
 
 func (d *SQLDB) releaseConn() {
@@ -123,7 +117,25 @@ func (d *SQLDB) releaseConn() {
 		return
 	}
 
-	<-d.concur
+	d.concur.Release(1)
+}
+
+// Concurrency returns the semaphore which limits the concurrency, it can
+// be used for monitoring and debugging. It returns nil if d was
+// created with no limit on concurrency.
+func (d *SQLDB) Concurrency() *chanutil.WeightedSemaphore {
+	return d.concur
+}
+
+// SetMaxConcurrent changes the concurrency limit at runtime, a lowered
+// limit takes effect as the running calls finish. It has no effect if
+// d was created with no limit on concurrency.
+func (d *SQLDB) SetMaxConcurrent(maxConcurrent int) {
+	if d.concur == nil || maxConcurrent <= 0 {
+		return
+	}
+
+	d.concur.SetLimit(maxConcurrent)
 }
 
 func (d *SQLDB) Close() error {
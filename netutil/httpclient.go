@@ -18,7 +18,8 @@ import (
 type HttpClient struct {
 	ts     *Transport
 	hc     *Client
-	concur chanutil.Semaphore
+	concur *chanutil.WeightedSemaphore
+	limit  chanutil.Limiter
 }
 
 // if maxConcurrent == 0, no limit on concurrency.
@@ -45,23 +46,29 @@ func NewHttpClient(maxConcurrent int, timeout time.Duration) *HttpClient {
 	c.ts = ts
 	c.hc = hc
 	if maxConcurrent > 0 {
-		c.concur = chanutil.NewSemaphore(maxConcurrent)
+		c.concur = chanutil.NewWeightedSemaphore(maxConcurrent)
 	}
 	return c
 }
 
+// SetLimiter sets the rate limiter of the requests, nil means no limit.
+// It must be called before the client is used.
+func (c *HttpClient) SetLimiter(l chanutil.Limiter) {
+	c.limit = l
+}
+
 func (c *HttpClient) acquireConn(ctx context.Context) error {
-	if c.concur == nil {
-		return nil
+	if c.limit != nil {
+		if err := c.limit.Wait(ctx); err != nil {
+			return err
+		}
 	}
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	// Acquire
-	case c.concur <- struct{}{}:
+	if c.concur == nil {
 		return nil
 	}
+
+	return c.concur.AcquireCtx(ctx, 1)
 }
 
 func (c *HttpClient) releaseConn() {
@@ -69,7 +76,25 @@ func (c *HttpClient) releaseConn() {
 		return
 	}
 
-	<-c.concur
+	c.concur.Release(1)
+}
+
+// Concurrency returns the semaphore which limits the concurrency, it can
+// be used for monitoring and debugging. It returns nil if c was
+// created with no limit on concurrency.
+func (c *HttpClient) Concurrency() *chanutil.WeightedSemaphore {
+	return c.concur
+}
+
+// SetMaxConcurrent changes the concurrency limit at runtime, a lowered
+// limit takes effect as the running calls finish. It has no effect if
+// c was created with no limit on concurrency.
+func (c *HttpClient) SetMaxConcurrent(maxConcurrent int) {
+	if c.concur == nil || maxConcurrent <= 0 {
+		return
+	}
+
+	c.concur.SetLimit(maxConcurrent)
 }
 
 func (c *HttpClient) Do(ctx context.Context,
@@ -140,7 +140,7 @@ func (h httpHandler) ContextServeHTTP(ctx context.Context,
 
 type maxConcurrentHandler struct {
 	oh           ContextHandler
-	concur       chanutil.Semaphore
+	concur       *chanutil.WeightedSemaphore
 	hesitateTime time.Duration
 	notifier     MaxConcurrentNotifier
 }
@@ -156,13 +156,25 @@ func NewMaxConcurrentHandler(oh ContextHandler,
 	if maxConcurrent <= 0 {
 		return oh
 	}
+
+	return NewMaxConcurrentHandlerEx(oh, chanutil.NewWeightedSemaphore(maxConcurrent),
+		hesitateTime, notifier)
+}
+
+// NewMaxConcurrentHandlerEx is like NewMaxConcurrentHandler, but the
+// concurrency is limited by the specified semaphore, so the caller can
+// tune or debug it.
+func NewMaxConcurrentHandlerEx(oh ContextHandler,
+	concur *chanutil.WeightedSemaphore, hesitateTime time.Duration,
+	notifier MaxConcurrentNotifier) ContextHandler {
+
 	if hesitateTime <= 0 {
 		hesitateTime = DefaultHesitateTime
 	}
 
 	return &maxConcurrentHandler{
 		oh:           oh,
-		concur:       chanutil.NewSemaphore(maxConcurrent),
+		concur:       concur,
 		hesitateTime: hesitateTime,
 		notifier:     notifier,
 	}
@@ -175,19 +187,21 @@ const (
 )
 
 func (h *maxConcurrentHandler) acquireConn(ctx context.Context) int {
-	select {
-	case <-ctx.Done():
-		return acquire_CtxDone
+	hctx, cancel := context.WithTimeout(ctx, h.hesitateTime)
+	defer cancel()
+
 	// Acquire
-	case h.concur <- struct{}{}:
+	if h.concur.AcquireCtx(hctx, 1) == nil {
 		return acquire_OK
-	case <-time.After(h.hesitateTime):
-		return acquire_Timeout
 	}
+	if ctx.Err() != nil {
+		return acquire_CtxDone
+	}
+	return acquire_Timeout
 }
 
 func (h *maxConcurrentHandler) releaseConn() {
-	<-h.concur
+	h.concur.Release(1)
 }
 
 func (h *maxConcurrentHandler) ContextServeHTTP(ctx context.Context,
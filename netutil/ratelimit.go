@@ -0,0 +1,97 @@
+// Copyright 2015 someonegg. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"github.com/someonegg/goutility/chanutil"
+	"golang.org/x/net/context"
+	"net"
+	"net/http"
+)
+
+type rateLimitHandler struct {
+	oh       ContextHandler
+	limit    chanutil.Limiter
+	keyed    *chanutil.KeyedLimiter
+	keyFn    func(r *http.Request) string
+	notifier RateLimitNotifier
+}
+
+// The ratelimit handler type is a middleware that can limit the
+// request rate. The requests over the limit are rejected at once.
+// if limit == nil, no limit on rate.
+func NewRateLimitHandler(oh ContextHandler, limit chanutil.Limiter,
+	notifier RateLimitNotifier) ContextHandler {
+
+	if limit == nil {
+		return oh
+	}
+
+	return &rateLimitHandler{
+		oh:       oh,
+		limit:    limit,
+		notifier: notifier,
+	}
+}
+
+// NewKeyedRateLimitHandler is like NewRateLimitHandler, but the request
+// rate is limited for each key, such as the client address.
+// if keyFn == nil, use RemoteIP.
+func NewKeyedRateLimitHandler(oh ContextHandler,
+	keyed *chanutil.KeyedLimiter, keyFn func(r *http.Request) string,
+	notifier RateLimitNotifier) ContextHandler {
+
+	if keyed == nil {
+		return oh
+	}
+	if keyFn == nil {
+		keyFn = RemoteIP
+	}
+
+	return &rateLimitHandler{
+		oh:       oh,
+		keyed:    keyed,
+		keyFn:    keyFn,
+		notifier: notifier,
+	}
+}
+
+func (h *rateLimitHandler) ContextServeHTTP(ctx context.Context,
+	w http.ResponseWriter, r *http.Request) {
+
+	limit := h.limit
+	if h.keyed != nil {
+		limit = h.keyed.Get(h.keyFn(r))
+	}
+	if !limit.Allow() {
+		h.notifier.OnRateLimit(w, r)
+		return
+	}
+
+	h.oh.ContextServeHTTP(ctx, w, r)
+}
+
+// RemoteIP returns the ip part of r.RemoteAddr.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type RateLimitNotifier interface {
+	OnRateLimit(w http.ResponseWriter, r *http.Request)
+}
+
+type defaultRateLimitNotifier struct{}
+
+func (n defaultRateLimitNotifier) OnRateLimit(
+	w http.ResponseWriter, r *http.Request) {
+
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+var DefaultRateLimitNotifier RateLimitNotifier = defaultRateLimitNotifier{}